@@ -2,14 +2,19 @@ package asana
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/manishrjain/asanawarrior/x"
@@ -17,60 +22,304 @@ import (
 )
 
 var token = flag.String("token", "", "Token provided by Asana.")
-var domain = flag.String("domain", "", "Workspace name, generally your domain name in Asana.")
+var domain = flag.String("domain", "", "Workspace name, generally your domain name in Asana."+
+	" Multiple workspaces can be synced by separating them with commas, the first being the default.")
 var verbose = flag.Bool("verbose", false, "Verbose output.")
+var nativeSections = flag.Bool("native_sections", true,
+	"Sync Asana sections, as retrieved from the sections of each project. Set to false for"+
+		" the legacy convention, where tasks with names ending in a colon act as sections.")
+var comments = flag.Bool("comments", false,
+	"Sync comments on Asana tasks as Taskwarrior annotations. Needs one extra request per task.")
+var dryRun = flag.Bool("dryrun", false,
+	"Log the changes which would be made to Asana and Taskwarrior, instead of making them.")
+var retries = flag.Int("retries", 5, "Number of times to retry a request rate limited by Asana.")
+var serverRetries = flag.Int("server_retries", 5,
+	"Number of times to retry a request failing with a 5xx server error from Asana.")
+var checkTags = flag.Bool("check_tags", false,
+	"Check Asana for an existing tag of the same name before creating one, in case another"+
+		" sync created it since the cache was updated. Needs one extra request per new tag.")
+var syncArchived = flag.Bool("archived", false, "Also sync the tasks of archived Asana projects.")
 var cache *acache = new(acache)
 
 const (
-	prefix = "https://app.asana.com/api/1.0"
-	stamp  = "2006-01-02T15:04:05.999Z"
+	prefix   = "https://app.asana.com/api/1.0"
+	stamp    = "2006-01-02T15:04:05.999Z"
+	dayStamp = "2006-01-02"
+	pageSize = 100 // Max allowed by Asana.
 )
 
-func runRequest(method, url string) ([]byte, error) {
-RUNLOOP:
-	if *verbose {
-		fmt.Printf("METHOD: %v URL: %v\n", method, url)
+// send issues the request to Asana, retrying on network errors. If Asana rate limits
+// us, it waits for as long as the Retry-After header asks (or backs off exponentially
+// if the header is absent), and retries up to -retries times. Server errors are retried
+// with jittered exponential backoff up to -server_retries times, except for creates,
+// which might have gone through.
+func send(ctx context.Context, method, url string, values url.Values) (*http.Response, error) {
+	return sendHeader(ctx, method, url, values, nil)
+}
+
+// sendHeader is like send, but adds the given headers to the request.
+func sendHeader(ctx context.Context, method, url string, values url.Values,
+	hdr http.Header) (*http.Response, error) {
+	limited, failed := 0, 0
+	for {
+		var body io.Reader
+		if values != nil {
+			body = bytes.NewBufferString(values.Encode())
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "send http.NewRequest"))
+		}
+
+		tok, err := bearer()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+tok)
+		for k, vs := range hdr {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		if values != nil {
+			req.Header.Add("content-type", "application/x-www-form-urlencoded")
+		}
+		logger.Debugf("HEADER: %+v", req.Header)
+
+		start := time.Now()
+		resp, err := getHTTPClient().Do(req)
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		recordCall(method, url, start, status)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if isCreate(method, url) {
+				return nil, errors.Wrapf(errUnconfirmed, "send method: [%v] url: [%v] err: [%v]",
+					method, url, err)
+			}
+			logger.Warnf("send method: [%v] url: [%v] err: [%v]", method, url, err)
+			if err := sleep(ctx, 5*time.Second); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			serr := &statusError{method: method, url: url, code: resp.StatusCode, body: body}
+			if isCreate(method, url) {
+				return nil, errors.Wrapf(errUnconfirmed, "send: %v", serr)
+			}
+			if failed >= *serverRetries {
+				return nil, errors.Wrapf(serr, "Giving up after %d attempts", failed+1)
+			}
+			wait := backoff(failed)
+			logger.Warnf("send method: [%v] url: [%v] status: [%v]. Retrying in %v",
+				method, url, http.StatusText(resp.StatusCode), wait)
+			if err := sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+			failed++
+			continue
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if limited >= *retries {
+			return nil, errors.Wrapf(ErrRateLimited, "Giving up after %d attempts. method: [%v] url: [%v]",
+				limited+1, method, url)
+		}
+		wait := retryAfter(resp, limited)
+		logger.Warnf("send method: [%v] url: [%v] rate limited. Retrying in %v", method, url, wait)
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		limited++
 	}
-	req, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		log.Fatal(err)
+}
+
+// sleep waits for d, returning ctx.Err() early if ctx is done before that.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// retryAfter returns how long to wait before retrying a rate limited request.
+func retryAfter(resp *http.Response, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return time.Second << uint(attempt)
+}
+
+// backoff returns a random duration in [d/2, d), where d doubles with every attempt,
+// so concurrent requests failing together don't all retry at once.
+func backoff(attempt int) time.Duration {
+	d := time.Second << uint(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// DryRun returns whether changes should only be logged, instead of being made.
+func DryRun() bool {
+	return *dryRun
+}
+
+// dryRunBody is returned instead of the Asana response, for writes skipped by -dryrun.
+var dryRunBody = []byte(`{"data":{}}`)
+
+// dryRunPrefix starts the placeholder ids of the projects and tags, which would have been
+// created if it wasn't for -dryrun.
+const dryRunPrefix = "dryrun-"
+
+// skipWrite logs the write and returns true, if it should be skipped due to -dryrun.
+// Each write is logged as a single tab separated line, so runs can be diffed.
+func skipWrite(method, url string, values url.Values) bool {
+	if !*dryRun || method == "GET" {
+		return false
 	}
+	fmt.Printf("DRYRUN\t%s\t%s\t%s\n", method, strings.TrimPrefix(url, prefix+"/"), values.Encode())
+	return true
+}
+
+// errUnconfirmed is returned if a request creating an entity failed in flight. Asana
+// might have created it regardless, so blindly retrying could create a duplicate.
+var errUnconfirmed = errors.New("Creation unconfirmed")
 
-	req.Header.Add("Authorization", "Bearer "+*token)
-	if *verbose {
-		fmt.Printf("HEADER: %+v\n", req.Header)
+// isCreate returns true if the request creates a new entity in Asana. Asana has no
+// idempotency keys, so these can't be retried safely.
+func isCreate(method, url string) bool {
+	if method != "POST" {
+		return false
 	}
+	path := strings.TrimPrefix(url, prefix+"/")
+	return path == "tasks" || path == "tags" || path == "projects" ||
+		(strings.HasPrefix(path, "workspaces/") && strings.HasSuffix(path, "/projects"))
+}
 
-	client := &http.Client{
-		Timeout: 10 * time.Minute,
+// Errors returned by requests to Asana, depending on how Asana responded. These are
+// wrapped with more context, so check for them via errors.Is.
+var (
+	ErrUnauthorized = errors.New("Unauthorized by Asana")
+	ErrForbidden    = errors.New("Forbidden by Asana")
+	ErrNotFound     = errors.New("Not found in Asana")
+	ErrRateLimited  = errors.New("Rate limited by Asana")
+	// ErrNoWorkspaces is returned if the token doesn't give access to any workspace at
+	// all, which usually means it's invalid or expired.
+	ErrNoWorkspaces = errors.New("No workspaces accessible with the Asana token")
+)
+
+// statusError is returned for requests rejected by Asana, which won't succeed if retried.
+type statusError struct {
+	method string
+	url    string
+	code   int
+	body   []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("method: [%v] url: [%v] status: [%v] body: %q",
+		e.method, e.url, http.StatusText(e.code), e.body)
+}
+
+// Is allows checking for the error matching the status code via errors.Is.
+func (e *statusError) Is(target error) bool {
+	switch e.code {
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
 	}
+	return false
+}
+
+// hasStatus returns true if err was caused by Asana responding with the status code.
+func hasStatus(err error, code int) bool {
+	se, ok := errors.Cause(err).(*statusError)
+	return ok && se.code == code
+}
+
+func runRequest(ctx context.Context, method, url string) ([]byte, error) {
+	body, _, _, err := runConditional(ctx, method, url, "")
+	return body, err
+}
 
-	resp, err := client.Do(req)
+// runConditional is like runRequest, but if etag is set, only retrieves the body if it
+// changed, via If-None-Match. It returns the ETag of the response, and whether Asana
+// responded with 304 Not Modified, in which case there's no body.
+func runConditional(ctx context.Context, method, url, etag string) ([]byte, string, bool, error) {
+	if skipWrite(method, url, nil) {
+		return dryRunBody, "", false, nil
+	}
+	var hdr http.Header
+	if etag != "" {
+		hdr = http.Header{"If-None-Match": []string{etag}}
+	}
+RUNLOOP:
+	logger.Debugf("METHOD: %v URL: %v", method, url)
+	resp, err := sendHeader(ctx, method, url, nil, hdr)
 	if err != nil {
-		log.Printf("runRequest method: [%v] url: [%v] err: [%v]", method, url, err)
-		time.Sleep(5 * time.Second)
-		goto RUNLOOP
+		return nil, "", false, err
 	}
-	code := resp.StatusCode
-	if code != http.StatusOK {
-		log.Printf("runRequest method: [%v] url: [%v] status: [%v]",
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		if etag == "" {
+			// Nothing to reuse, and asking again won't change that.
+			return nil, "", false, &statusError{method: method, url: url, code: resp.StatusCode}
+		}
+		return nil, etag, true, nil
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, "", false, &statusError{method: method, url: url, code: resp.StatusCode, body: body}
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Warnf("runRequest method: [%v] url: [%v] status: [%v]",
 			method, url, http.StatusText(resp.StatusCode))
-		time.Sleep(5 * time.Second)
+		resp.Body.Close()
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return nil, "", false, err
+		}
 		goto RUNLOOP
 	}
 	defer resp.Body.Close()
-	return ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, resp.Header.Get("ETag"), false, err
 }
 
-func runGetter(i interface{}, suffix string, fields ...string) error {
-	var url string
+func runGetter(ctx context.Context, i interface{}, suffix string, fields ...string) error {
+	q := url.Values{}
 	if len(fields) > 0 {
-		url = fmt.Sprintf("%s/%s?opt_fields=%s", prefix, suffix, strings.Join(fields, ","))
-	} else {
-		url = fmt.Sprintf("%s/%s", prefix, suffix)
+		q.Set("opt_fields", strings.Join(fields, ","))
 	}
+	return runQuery(ctx, i, suffix, q)
+}
 
-	body, err := runRequest("GET", url)
+// runQuery runs a GET against suffix with the given query parameters, and unmarshals
+// the response into i.
+func runQuery(ctx context.Context, i interface{}, suffix string, q url.Values) error {
+	url := fmt.Sprintf("%s/%s", prefix, suffix)
+	if len(q) > 0 {
+		url += "?" + q.Encode()
+	}
+
+	body, err := runRequest(ctx, "GET", url)
 	if err != nil {
 		return errors.Wrapf(err, "runGetter: %q", body)
 	}
@@ -84,20 +333,119 @@ type Basic struct {
 	Id    string `json:"gid"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
+	// FullEmail is only set for users, whose Email gets shortened to the part before @.
+	FullEmail string `json:"full_email,omitempty"`
+	// Archived is only set for projects.
+	Archived bool `json:"archived,omitempty"`
 }
 type BasicData struct {
-	Data []Basic `json:"data"`
+	Data     []Basic   `json:"data"`
+	NextPage *nextPage `json:"next_page"`
+}
+
+// rawData is a page of entries of any kind, to be unmarshalled by the caller.
+type rawData struct {
+	Data     []json.RawMessage `json:"data"`
+	NextPage *nextPage         `json:"next_page"`
+}
+
+// nextPage is returned by Asana when there are more results to be fetched.
+type nextPage struct {
+	Offset string `json:"offset"`
 }
 type BasicDataOne struct {
 	Data Basic `json:"data"`
 }
 
-func getVarious(suffix string, opts ...string) ([]Basic, error) {
-	var bd BasicData
-	if err := runGetter(&bd, suffix, opts...); err != nil {
+// getVarious retrieves all the entries under suffix, with the given opt_fields, as
+// Basic objects.
+func getVarious(ctx context.Context, suffix string, opts ...string) ([]Basic, error) {
+	q := url.Values{}
+	if len(opts) > 0 {
+		q.Set("opt_fields", strings.Join(opts, ","))
+	}
+	entries, err := getEntries(ctx, suffix, q)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Basic, len(entries))
+	for i, e := range entries {
+		if err := json.Unmarshal(e, &result[i]); err != nil {
+			return nil, errors.Wrapf(err, "Unmarshal: %q", e)
+		}
+	}
+	return result, nil
+}
+
+// getEntries retrieves all the entries under suffix matching the query, following
+// Asana's pagination until there are no more pages left. Each entry is left for the
+// caller to unmarshal, so any number of opt_fields can be asked for in one go.
+func getEntries(ctx context.Context, suffix string, q url.Values) ([]json.RawMessage, error) {
+	q = cloneValues(q)
+	q.Set("limit", strconv.Itoa(pageSize))
+
+	var result []json.RawMessage
+	for page := 0; ; page++ {
+		url := fmt.Sprintf("%s/%s?%s", prefix, suffix, q.Encode())
+		var body []byte
+		var err error
+		if isReference(suffix) {
+			body, err = getPage(ctx, url, fmt.Sprintf("%s?%s#%d", suffix, q.Get("opt_fields"), page))
+		} else {
+			body, err = runRequest(ctx, "GET", url)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "getEntries: %q", suffix)
+		}
+		var rd rawData
+		if err := json.Unmarshal(body, &rd); err != nil {
+			return nil, errors.Wrapf(err, "Unmarshal: %q", body)
+		}
+		result = append(result, rd.Data...)
+		if rd.NextPage == nil || rd.NextPage.Offset == "" {
+			return result, nil
+		}
+		q.Set("offset", rd.NextPage.Offset)
+	}
+}
+
+// isReference returns true if suffix lists the projects, tags or users of a workspace or
+// team. Those rarely change, unlike tasks, so their pages are worth keeping ETags for.
+func isReference(suffix string) bool {
+	parts := strings.Split(suffix, "/")
+	if len(parts) != 3 || (parts[0] != "workspaces" && parts[0] != "teams") {
+		return false
+	}
+	switch parts[2] {
+	case "projects", "tags", "users":
+		return true
+	}
+	return false
+}
+
+// getPage retrieves a page of a listing, stored under key. If the page was retrieved
+// before, it's only downloaded again if it changed since, via its ETag. Each page is
+// checked on its own, as any of them can change without the others doing so.
+func getPage(ctx context.Context, url, key string) ([]byte, error) {
+	prev := cachedPage(key)
+	body, etag, notModified, err := runConditional(ctx, "GET", url, prev.etag)
+	if err != nil {
 		return nil, err
 	}
-	return bd.Data, nil
+	if notModified {
+		return prev.body, nil
+	}
+	storePage(key, etag, body)
+	return body, nil
+}
+
+// cloneValues returns a copy of q, which can be modified without affecting q.
+func cloneValues(q url.Values) url.Values {
+	c := make(url.Values, len(q))
+	for k, vs := range q {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
 }
 
 type psec struct {
@@ -107,12 +455,28 @@ type psec struct {
 
 type task struct {
 	Basic
-	Assignee    Basic   `json:"assignee"`
-	Tags        []Basic `json:"tags"`
-	CompletedAt string  `json:"completed_at"`
-	ModifiedAt  string  `json:"modified_at"`
-	CreatedAt   string  `json:"created_at"`
-	Memberships []psec  `json:"memberships"`
+	Assignee     *Basic  `json:"assignee"` // Nil if unassigned.
+	AssigneeStat string  `json:"assignee_status"`
+	Tags         []Basic `json:"tags"`
+	Followers    []Basic `json:"followers"`
+	Dependencies []Basic `json:"dependencies"`
+	Completed    bool    `json:"completed"`
+	CompletedAt  string  `json:"completed_at"`
+	ModifiedAt   string  `json:"modified_at"`
+	CreatedAt    string  `json:"created_at"`
+	CreatedBy    *Basic  `json:"created_by"`
+	DueOn        string  `json:"due_on"`
+	DueAt        string  `json:"due_at"`
+	StartOn      string  `json:"start_on"`
+	StartAt      string  `json:"start_at"`
+	Notes        string  `json:"notes"`
+	Parent       *Basic  `json:"parent"`
+	Permalink    string  `json:"permalink_url"`
+	NumHearts    int     `json:"num_hearts"`
+	NumLikes     int     `json:"num_likes"`
+	Memberships  []psec  `json:"memberships"`
+
+	CustomFields []customField `json:"custom_fields"`
 }
 
 type tasks struct {
@@ -125,6 +489,7 @@ type oneTask struct {
 
 func convert(tsk task, proj, section string) (x.WarriorTask, error) {
 	e := x.WarriorTask{}
+	wid, _ := cache.FindProject(proj)
 
 	mts, err := time.Parse(stamp, tsk.ModifiedAt)
 	if err != nil {
@@ -134,73 +499,282 @@ func convert(tsk task, proj, section string) (x.WarriorTask, error) {
 	if err != nil {
 		return e, errors.Wrap(err, "asana created at")
 	}
+	// Reopened tasks may still carry a completed_at, so only trust it for completed ones.
 	var dts time.Time
-	if len(tsk.CompletedAt) > 0 {
+	if tsk.Completed && len(tsk.CompletedAt) > 0 {
 		dts, err = time.Parse(stamp, tsk.CompletedAt)
 		if err != nil {
 			return e, errors.Wrap(err, "asana completed at")
 		}
+	} else if tsk.Completed {
+		dts = mts
+	}
+
+	due, err := parseDate(tsk.DueAt, tsk.DueOn)
+	if err != nil {
+		return e, errors.Wrap(err, "asana due")
+	}
+	start, err := parseDate(tsk.StartAt, tsk.StartOn)
+	if err != nil {
+		return e, errors.Wrap(err, "asana start")
 	}
 
 	wt := x.WarriorTask{
-		Name:      tsk.Name,
+		Name:      transformName(ToTaskwarrior, tsk.Name),
+		Notes:     tsk.Notes,
+		Priority:  toPriority(wid, tsk.CustomFields),
 		Project:   proj,
 		Xid:       tsk.Id,
-		Assignee:  cache.User(tsk.Assignee.Id),
 		Modified:  mts,
 		Created:   cts,
 		Completed: dts,
+		Due:       due,
+		Start:     start,
 		Section:   section,
+		URL:       tsk.Permalink,
+		Hearts:    tsk.NumHearts,
+	}
+	if tsk.NumLikes > wt.Hearts {
+		// Hearts have been renamed to likes.
+		wt.Hearts = tsk.NumLikes
 	}
+	if tsk.Assignee != nil {
+		wt.Assignee = cache.User(tsk.Assignee.Id)
+		wt.AssigneeStatus = tsk.AssigneeStat
+	}
+	if tsk.CreatedBy != nil {
+		if wt.CreatedBy = cache.User(tsk.CreatedBy.Id); wt.CreatedBy == "" {
+			logger.Debugf("Creator %v of task %v isn't a cached user", tsk.CreatedBy.Id, tsk.Id)
+		}
+	}
+	wt.CustomFields = toCustomFields(tsk.CustomFields)
+	if tsk.Parent != nil {
+		wt.Parent = tsk.Parent.Id
+	}
+	var tids []string
 	for _, tag := range tsk.Tags {
-		wt.Tags = append(wt.Tags, cache.Tag(tag.Id))
+		tids = append(tids, tag.Id)
+	}
+	wt.Tags = cache.TagNames(tids)
+	for _, m := range tsk.Memberships {
+		if m.Project.Name != proj && isTagProject(m.Project.Name) {
+			wt.Tags = append(wt.Tags, m.Project.Name)
+		}
+	}
+	wt.Projects = otherProjects(tsk, proj)
+	for _, d := range tsk.Dependencies {
+		wt.Depends = append(wt.Depends, d.Id)
+	}
+	for _, f := range tsk.Followers {
+		if name := cache.User(f.Id); name != "" {
+			wt.Followers = append(wt.Followers, name)
+		}
 	}
 	return wt, nil
 }
 
-func getTasks(proj Basic, out chan x.WarriorTask, errc chan error) {
+// taskFields are the fields retrieved for every task in a project.
+var taskFields = []string{
+	"assignee", "name", "tags", "completed", "completed_at", "modified_at", "created_at", "due_on", "due_at",
+	"start_on", "start_at",
+	"notes", "custom_fields.name", "custom_fields.type", "custom_fields.text_value",
+	"custom_fields.enum_value.name", "custom_fields.enum_options.name",
+	"memberships.project.name", "memberships.section.name", "parent",
+	"permalink_url", "followers", "dependencies", "created_by", "assignee_status",
+	"num_hearts", "num_likes",
+}
+
+// assigneeStatuses are the valid values of the assignee_status of an Asana task.
+var assigneeStatuses = []string{"inbox", "today", "upcoming", "later"}
+
+// checkAssigneeStatus returns an error if status isn't a valid assignee_status.
+func checkAssigneeStatus(status string) error {
+	for _, s := range assigneeStatuses {
+		if s == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid assignee status %q. One of: %s", status,
+		strings.Join(assigneeStatuses, ", "))
+}
+
+type story struct {
+	Basic
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+	CreatedBy Basic  `json:"created_by"`
+}
+
+type stories struct {
+	Data []story `json:"data"`
+}
+
+// addComments sets the comments on the Asana task as annotations, if -comments is set.
+// Only comments made by people are considered, not the stories Asana generates for
+// changes to the task. Each one is prefixed with its author and time.
+func addComments(ctx context.Context, wt *x.WarriorTask) error {
+	if !*comments {
+		return nil
+	}
+	var st stories
+	if err := runGetter(ctx, &st, fmt.Sprintf("tasks/%s/stories", wt.Xid),
+		"type", "text", "created_at", "created_by.name"); err != nil {
+		return errors.Wrap(err, "addComments")
+	}
+	for _, s := range st.Data {
+		if s.Type != "comment" {
+			continue
+		}
+		author := cache.User(s.CreatedBy.Id)
+		if author == "" {
+			author = s.CreatedBy.Name
+		}
+		ts := s.CreatedAt
+		if t, err := time.Parse(stamp, s.CreatedAt); err == nil {
+			ts = t.Local().Format("2006-01-02 15:04")
+		}
+		wt.Annotations = append(wt.Annotations, fmt.Sprintf("%s %s: %s", author, ts, s.Text))
+	}
+	return nil
+}
+
+// getTasks sends out the tasks of the project. If since is set, only the tasks
+// modified since then are retrieved.
+func getTasks(ctx context.Context, proj Basic, since time.Time,
+	out chan x.WarriorTask, errc chan error) {
 	var sectionName string
-	var t tasks
-	if err := runGetter(&t, fmt.Sprintf("projects/%s/tasks", proj.Id),
-		"assignee,name,tags,completed_at,modified_at,created_at"); err != nil {
+	q := url.Values{}
+	q.Set("opt_fields", strings.Join(taskFields, ","))
+	suffix := fmt.Sprintf("projects/%s/tasks", proj.Id)
+	if !since.IsZero() {
+		suffix = "tasks"
+		q.Set("project", proj.Id)
+		q.Set("modified_since", since.UTC().Format(time.RFC3339))
+	}
+	entries, err := getEntries(ctx, suffix, q)
+	if err != nil {
 		errc <- errors.Wrapf(err, "getTasks for project: %v", proj.Name)
 		return
 	}
+	var t tasks
+	t.Data = make([]task, len(entries))
+	for i, e := range entries {
+		if err := json.Unmarshal(e, &t.Data[i]); err != nil {
+			errc <- errors.Wrapf(err, "getTasks for project: %v: %q", proj.Name, e)
+			return
+		}
+	}
+	if *nativeSections {
+		if err := cache.InvalidateSections(ctx, proj.Id); err != nil {
+			errc <- errors.Wrapf(err, "getTasks sections for project: %v", proj.Name)
+			return
+		}
+	}
 
 	for _, tsk := range t.Data {
 		if len(tsk.Name) == 0 {
 			// Don't sync such tasks.
 			continue
 		}
-		if strings.HasSuffix(tsk.Name, ":") {
+		if !*nativeSections && strings.HasSuffix(tsk.Name, ":") {
 			sec := Basic{
 				Id:   tsk.Id,
 				Name: tsk.Name,
 			}
 			sectionName = cache.AddSection(proj.Id, sec)
+			if !since.IsZero() {
+				// The tasks following it in the delta aren't necessarily in it.
+				sectionName = ""
+			}
 			continue
 		}
 
-		wt, err := convert(tsk, proj.Name, sectionName)
+		if isTagProject(proj.Name) && inOtherProject(tsk) {
+			// The task gets synced via its other project, with this one as a tag.
+			continue
+		}
+		if m, ok := primaryMembership(tsk); ok && m.Project.Id != proj.Id {
+			// The task gets synced via its primary project, with this one in Projects.
+			continue
+		}
+
+		sname := sectionName
+		for _, m := range tsk.Memberships {
+			if m.Project.Id == proj.Id && m.Section.Id != "" {
+				if name := cache.AddSectionObject(proj.Id, m.Section); name != "" {
+					sname = name
+				}
+			}
+		}
+
+		wt, err := convert(tsk, proj.Name, sname)
 		if err != nil {
 			errc <- errors.Wrapf(err, "convert: getTasks for project: %v", proj.Name)
 			return
 		}
+		if err := addComments(ctx, &wt); err != nil {
+			errc <- errors.Wrapf(err, "comments: getTasks for project: %v", proj.Name)
+			return
+		}
 		out <- wt
 	}
 	errc <- nil
 }
 
-func GetTasks() ([]x.WarriorTask, error) {
-	if err := cache.update(); err != nil {
+// LoadCache loads the cache of workspaces, projects, tags and users stored at path by
+// SaveCache. The next GetTasks serves from it, while updating it in the background.
+func LoadCache(path string) error {
+	return cache.Load(path)
+}
+
+// SaveCache stores the current cache at path.
+func SaveCache(path string) error {
+	return cache.Save(path)
+}
+
+// GetTasks updates the cache, and retrieves the tasks of all the synced projects.
+// Cancelling ctx aborts the requests in flight, like it does for the other calls taking
+// one.
+func GetTasks(ctx context.Context) ([]x.WarriorTask, error) {
+	if err := cache.updateStale(ctx); err != nil {
 		return nil, errors.Wrap(err, "cache.update")
 	}
+	if err := listArchivedTasks(ctx); err != nil {
+		return nil, err
+	}
+	if !*incremental {
+		return fetchTasks(ctx, time.Time{})
+	}
+
+	lastSync.Lock()
+	defer lastSync.Unlock()
+	start := time.Now()
+	wtasks, err := fetchTasks(ctx, lastSync.at)
+	if err != nil {
+		return wtasks, err
+	}
+	if !lastSync.at.IsZero() {
+		if wtasks, err = mergeDelta(ctx, lastSync.tasks, wtasks); err != nil {
+			return nil, err
+		}
+	}
+	lastSync.at = start
+	lastSync.tasks = make(map[string]x.WarriorTask, len(wtasks))
+	for _, wt := range wtasks {
+		lastSync.tasks[wt.Xid] = wt
+	}
+	return wtasks, nil
+}
 
+// fetchTasks retrieves the tasks of all the projects, or only the ones modified since
+// the given time if it's set.
+func fetchTasks(ctx context.Context, since time.Time) ([]x.WarriorTask, error) {
 	out := make(chan x.WarriorTask, 100)
-	projects := cache.Projects()
+	projects := syncedProjects()
 	errc := make(chan error, len(projects))
 	for _, proj := range projects {
-		go getTasks(proj, out, errc)
+		go getTasks(ctx, proj, since, out, errc)
 	}
 
 	// Asana can send back the same task multiple times, if it's part of multiple projects.
@@ -231,110 +805,310 @@ func GetTasks() ([]x.WarriorTask, error) {
 	return wtasks, rerr
 }
 
-// runPost would run a PUT or POST to Asana. No locks should be acquired.
-func runPost(method, suffix string, values url.Values) ([]byte, error) {
-POSTLOOP:
-	url := fmt.Sprintf("%s/%s", prefix, suffix)
-	fmt.Println(url, values.Encode())
-	req, err := http.NewRequest(method, url, bytes.NewBufferString(values.Encode()))
-	if err != nil {
-		log.Fatal(errors.Wrap(err, "runPost http.NewRequest"))
+// syncedProjects returns the projects whose tasks get synced, leaving out archived ones
+// unless -archived is set.
+func syncedProjects() []Basic {
+	if *syncArchived {
+		return cache.AllProjects()
 	}
+	return cache.ActiveProjects()
+}
 
-	req.Header.Add("Authorization", "Bearer "+*token)
-	req.Header.Add("content-type", "application/x-www-form-urlencoded")
-	client := &http.Client{
-		Timeout: 10 * time.Minute,
+// archivedTasks holds the ids of the tasks in archived projects as of the last GetTasks,
+// unless -archived is set. Such tasks aren't synced, and are told apart from deleted ones
+// via this, instead of asking Asana about each one.
+var archivedTasks struct {
+	sync.Mutex
+	ids map[string]bool
+}
+
+// listArchivedTasks records the ids of the tasks in the archived projects, which don't
+// get synced.
+func listArchivedTasks(ctx context.Context) error {
+	ids := make(map[string]bool)
+	for _, proj := range cache.AllProjects() {
+		if *syncArchived || !proj.Archived {
+			continue
+		}
+		list, err := getVarious(ctx, fmt.Sprintf("projects/%s/tasks", proj.Id), "gid")
+		if err != nil {
+			return errors.Wrapf(err, "listArchivedTasks for project: %v", proj.Name)
+		}
+		for _, t := range list {
+			ids[t.Id] = true
+		}
+	}
+	archivedTasks.Lock()
+	defer archivedTasks.Unlock()
+	archivedTasks.ids = ids
+	return nil
+}
+
+// runPost would run a PUT, POST or DELETE to Asana. No locks should be acquired. Writes
+// rejected by Asana result in a statusError, like for runRequest.
+func runPost(ctx context.Context, method, suffix string, values url.Values) ([]byte, error) {
+	url := fmt.Sprintf("%s/%s", prefix, suffix)
+	if skipWrite(method, url, values) {
+		return dryRunBody, nil
 	}
-	resp, err := client.Do(req)
+	logger.Debugf("%s %s", url, values.Encode())
+	resp, err := send(ctx, method, url, values)
 	if err != nil {
-		log.Printf("runPost url: [%v] err: [%v]", url, err)
-		time.Sleep(5 * time.Second)
-		goto POSTLOOP
+		return nil, errors.Wrap(err, "runPost")
 	}
 	defer resp.Body.Close()
 
-	return ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "runPost")
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &statusError{method: method, url: url, code: resp.StatusCode, body: body}
+	}
+	return body, nil
 }
 
-func toTagIds(tnames []string) []string {
-	var tags []string
-	for _, t := range tnames {
-		tid := cache.TagId(t)
-		if tid == "" {
-			tid = cache.CreateTag(t)
-			fmt.Printf("New Tag created. ID: %s", tid)
-		}
-		if tid != "" {
-			tags = append(tags, tid)
-		}
+// toTagIds returns the ids of the named tags in workspace wid, creating the missing ones.
+func toTagIds(ctx context.Context, wid string, tnames []string) ([]string, error) {
+	if len(tnames) == 0 {
+		return nil, nil
 	}
-	return tags
+	tags, err := cache.EnsureTags(ctx, wid, tnames)
+	return tags, errors.Wrap(err, "toTagIds")
 }
 
-func removeProject(tid, pid string) error {
+func removeProject(ctx context.Context, tid, pid string) error {
 	v := url.Values{}
 	v.Add("project", pid)
-	_, err := runPost("POST", fmt.Sprintf("tasks/%s/removeProject", tid), v)
+	_, err := runPost(ctx, "POST", fmt.Sprintf("tasks/%s/removeProject", tid), v)
 	return err
 }
 
-func updateSection(tid, pid string, section string) error {
-	v := url.Values{}
-	v.Add("project", pid)
+func updateSection(ctx context.Context, tid, pid string, section string) error {
+	if sid := cache.SectionId(pid, section); sid != "" {
+		return cache.MoveTaskToSection(ctx, tid, pid, sid)
+	}
+
+	return addProject(ctx, tid, pid)
+}
+
+// addDate sets an Asana date, like due or start, if there's any. Dates without a time of
+// day are sent as <field>_on, otherwise as <field>_at.
+func addDate(v url.Values, field string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if x.IsAllDay(t) {
+		v.Add(field+"_on", t.Local().Format(dayStamp))
+	} else {
+		v.Add(field+"_at", t.UTC().Format(time.RFC3339))
+	}
+}
+
+// updateDate sets or clears the Asana date, if it changed in Taskwarrior.
+func updateDate(v url.Values, field string, tw, asana time.Time) {
+	switch {
+	case tw.Equal(asana):
+	case !tw.IsZero():
+		addDate(v, field, tw)
+	case x.IsAllDay(asana):
+		v.Add(field+"_on", "null")
+	default:
+		v.Add(field+"_at", "null")
+	}
+}
 
-	sid := cache.SectionId(pid, section)
-	if sid != "" {
-		v.Add("section", sid)
+// parseDate parses an Asana date from its <field>_at and <field>_on values. All day
+// dates are kept at local midnight. See x.IsAllDay.
+func parseDate(at, on string) (time.Time, error) {
+	if len(at) > 0 {
+		return time.Parse(stamp, at)
 	}
+	if len(on) > 0 {
+		return time.ParseInLocation(dayStamp, on, time.Local)
+	}
+	return time.Time{}, nil
+}
 
-	_, err := runPost("POST", fmt.Sprintf("tasks/%s/addProject", tid), v)
+// SetParent makes the task a subtask of parent. An empty parent turns it back into a
+// top level task.
+func SetParent(ctx context.Context, tid, parent string) error {
+	if parent == "" {
+		parent = "null"
+	}
+	v := url.Values{}
+	v.Add("parent", parent)
+	_, err := runPost(ctx, "POST", fmt.Sprintf("tasks/%s/setParent", tid), v)
 	return err
 }
 
-func AddNew(wt x.WarriorTask) (x.WarriorTask, error) {
+// createTask POSTs the task, created in project pid, and returns its id. If the request
+// failed in flight, Asana might have created it regardless. So, the project is checked
+// for a task with the same name, before posting again.
+func createTask(ctx context.Context, pid string, v url.Values) (string, error) {
+	resp, err := runPost(ctx, "POST", "tasks", v)
+	if errors.Cause(err) == errUnconfirmed {
+		name := v.Get("name")
+		logger.Warnf("Unable to confirm creation of task %q: %v. Checking before retrying.", name, err)
+		list, lerr := getVarious(ctx, "projects/"+pid+"/tasks", "name")
+		if lerr != nil {
+			return "", errors.Wrapf(lerr, "createTask check: %q", name)
+		}
+		for _, b := range list {
+			if b.Name == name {
+				return b.Id, nil
+			}
+		}
+		resp, err = runPost(ctx, "POST", "tasks", v)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "createTask runPost")
+	}
+	logger.Debugf("%s", resp)
+
+	var ot oneTask
+	if err := json.Unmarshal(resp, &ot); err != nil {
+		return "", errors.Wrap(err, "createTask unmarshal")
+	}
+	return ot.Data.Id, nil
+}
+
+func AddNew(ctx context.Context, wt x.WarriorTask) (x.WarriorTask, error) {
 	e := x.WarriorTask{}
+	if err := Validate(wt); err != nil {
+		return e, errors.Wrap(err, "AddNew")
+	}
 
 	// Ensure that project actually exists before proceeding.
-	pid := cache.ProjectId(wt.Project)
+	wid, pid, err := resolveProject(ctx, wt.Project)
+	if err != nil {
+		return e, errors.Wrap(err, "AddNew")
+	}
 	if pid == "" {
-		return e, fmt.Errorf("Project not found: %v", wt.Project)
+		return e, fmt.Errorf("Project not found: %q", wt.Project)
 	}
 
 	v := url.Values{}
-	v.Add("workspace", cache.Workspace())
-	v.Add("name", wt.Name)
-	aid := cache.UserId(wt.Assignee)
-	if aid != "" {
-		v.Add("assignee", aid)
+	v.Add("workspace", wid)
+	v.Add("projects", pid)
+	v.Add("name", transformName(ToAsana, wt.Name))
+	if len(wt.Notes) > 0 {
+		v.Add("notes", wt.Notes)
+	}
+	if wt.Assignee != "" {
+		if aid := cache.WorkspaceUserId(wid, wt.Assignee); aid != "" {
+			v.Add("assignee", aid)
+			if wt.AssigneeStatus != "" {
+				if err := checkAssigneeStatus(wt.AssigneeStatus); err != nil {
+					return e, errors.Wrap(err, "AddNew")
+				}
+				v.Add("assignee_status", wt.AssigneeStatus)
+			}
+		}
 	}
 	if !wt.Completed.IsZero() {
 		v.Add("completed", "true")
 	}
+	addDate(v, "due", wt.Due)
+	addDate(v, "start", wt.Start)
 
-	tags := toTagIds(wt.Tags)
+	plainTags, projectTags := splitTags(wt.Tags)
+	tags, err := toTagIds(ctx, wid, plainTags)
+	if err != nil {
+		return e, errors.Wrap(err, "AddNew toTagIds")
+	}
 	v.Add("tags", strings.Join(tags, ","))
-	resp, err := runPost("POST", "tasks", v)
+	if followers := toUserIds(wid, wt.Followers); len(followers) > 0 {
+		v.Add("followers", strings.Join(followers, ","))
+	}
+	tid, err := createTask(ctx, pid, v)
 	if err != nil {
-		return e, errors.Wrap(err, "AddNew runPost")
+		return e, errors.Wrap(err, "AddNew")
 	}
-	fmt.Println(string(resp))
-
-	var ot oneTask
-	if err := json.Unmarshal(resp, &ot); err != nil {
-		return e, errors.Wrap(err, "AddNew unmarshal")
+	if *dryRun && tid == "" {
+		// Nothing got created, so make up an id to log the rest of the writes with.
+		tid = dryRunPrefix + wt.Uuid
 	}
-	if ot.Data.Id == "" {
-		return e, fmt.Errorf("Unable to find ID assigned by Asana: %+v", ot.Data)
+	if tid == "" {
+		return e, fmt.Errorf("Unable to find ID assigned by Asana to task: %q", wt.Name)
 	}
 
 	// Now set the project and section.
-	if err := updateSection(ot.Data.Id, pid, wt.Section); err != nil {
+	if err := updateSection(ctx, tid, pid, wt.Section); err != nil {
 		return e, errors.Wrap(err, "AddNew updateSection")
 	}
+	if err := addProjects(ctx, tid, without(wt.Projects, wt.Project)); err != nil {
+		return e, errors.Wrap(err, "AddNew")
+	}
+
+	if wt.Parent != "" && wt.Parent != x.PendingParent {
+		if err := SetParent(ctx, tid, wt.Parent); err != nil {
+			return e, errors.Wrap(err, "AddNew SetParent")
+		}
+	}
+	if err := addTagProjects(ctx, tid, projectTags); err != nil {
+		return e, errors.Wrap(err, "AddNew")
+	}
+	if err := AddDependencies(ctx, tid, wt.Depends); err != nil {
+		return e, errors.Wrap(err, "AddNew AddDependencies")
+	}
+
+	// Custom fields can only be set once the task is part of the project.
+	pv := url.Values{}
+	if wt.Priority != "" {
+		addPriority(pv, wid, wt.Priority)
+	}
+	addCustomFields(pv, wid, wt.CustomFields, nil)
+	if len(pv) > 0 {
+		if _, err := runPost(ctx, "PUT", "tasks/"+tid, pv); err != nil {
+			return e, errors.Wrap(err, "AddNew priority")
+		}
+	}
 
+	if *dryRun {
+		wt.Xid = tid
+		return wt, nil
+	}
 	// Now retrieve the task back again so we can sync it up with TW.
-	return GetOneTask(ot.Data.Id)
+	return GetOneTask(ctx, tid)
+}
+
+// toUserIds returns the ids of the named users in the workspace. Unknown users are
+// skipped.
+func toUserIds(wid string, names []string) []string {
+	var ids []string
+	for _, name := range names {
+		uid := cache.WorkspaceUserId(wid, name)
+		if uid == "" {
+			logger.Warnf("Skipping unknown user: %q", name)
+			continue
+		}
+		ids = append(ids, uid)
+	}
+	return ids
+}
+
+// updateFollowers adds and removes followers of the Asana task, to match Taskwarrior.
+func updateFollowers(ctx context.Context, tw x.WarriorTask, asana x.WarriorTask) error {
+	wid, _ := cache.FindProject(asana.Project)
+	for _, u := range []struct {
+		instruction string
+		ids         []string
+	}{
+		{"addFollowers", toUserIds(wid, diff(tw.Followers, asana.Followers))},
+		{"removeFollowers", toUserIds(wid, diff(asana.Followers, tw.Followers))},
+	} {
+		if len(u.ids) == 0 {
+			continue
+		}
+		v := url.Values{}
+		v.Add("followers", strings.Join(u.ids, ","))
+		suffix := fmt.Sprintf("tasks/%s/%s", tw.Xid, u.instruction)
+		if _, err := runPost(ctx, "POST", suffix, v); err != nil {
+			return errors.Wrapf(err, "updateFollowers %s", u.instruction)
+		}
+	}
+	return nil
 }
 
 func diff(t1 []string, t2 []string) []string {
@@ -352,11 +1126,11 @@ func diff(t1 []string, t2 []string) []string {
 	return result
 }
 
-func updateOneTag(tagid, taskid, instruction string, errc chan error) {
+func updateOneTag(ctx context.Context, tagid, taskid, instruction string, errc chan error) {
 	v := url.Values{}
 	v.Add("tag", tagid)
 	suffix := fmt.Sprintf("tasks/%s/%s", taskid, instruction)
-	_, err := runPost("POST", suffix, v)
+	_, err := runPost(ctx, "POST", suffix, v)
 	if err != nil {
 		errc <- errors.Wrap(err, "updateTags")
 		return
@@ -364,21 +1138,38 @@ func updateOneTag(tagid, taskid, instruction string, errc chan error) {
 	errc <- nil
 }
 
-func updateTags(tw x.WarriorTask, asana x.WarriorTask) error {
+// updateTags adds and removes the tags of the Asana task in workspace wid, to match
+// Taskwarrior.
+func updateTags(ctx context.Context, wid string, tw x.WarriorTask, asana x.WarriorTask) error {
 	taskid := tw.Xid
-	add := diff(tw.Tags, asana.Tags)
-	rem := diff(asana.Tags, tw.Tags)
+	twTags, twProjects := splitTags(tw.Tags)
+	asanaTags, asanaProjects := splitTags(asana.Tags)
+	if err := addTagProjects(ctx, taskid, diff(twProjects, asanaProjects)); err != nil {
+		return errors.Wrap(err, "updateTags")
+	}
+	if err := removeTagProjects(ctx, taskid, diff(asanaProjects, twProjects)); err != nil {
+		return errors.Wrap(err, "updateTags")
+	}
 
-	addids := toTagIds(add)
-	remids := toTagIds(rem)
+	add := diff(twTags, asanaTags)
+	rem := diff(asanaTags, twTags)
+
+	addids, err := toTagIds(ctx, wid, add)
+	if err != nil {
+		return errors.Wrap(err, "updateTags add")
+	}
+	remids, err := toTagIds(ctx, wid, rem)
+	if err != nil {
+		return errors.Wrap(err, "updateTags remove")
+	}
 	sz := len(addids) + len(remids)
 
 	errc := make(chan error, sz)
 	for _, id := range addids {
-		go updateOneTag(id, taskid, "addTag", errc)
+		go updateOneTag(ctx, id, taskid, "addTag", errc)
 	}
 	for _, id := range remids {
-		go updateOneTag(id, taskid, "removeTag", errc)
+		go updateOneTag(ctx, id, taskid, "removeTag", errc)
 	}
 
 	var rerr error
@@ -391,17 +1182,41 @@ func updateTags(tw x.WarriorTask, asana x.WarriorTask) error {
 	return rerr
 }
 
-func UpdateTask(tw x.WarriorTask, asana x.WarriorTask) error {
+func UpdateTask(ctx context.Context, tw x.WarriorTask, asana x.WarriorTask) error {
+	if err := Validate(tw); err != nil {
+		return errors.Wrap(err, "asana.UpdateTask")
+	}
 	v := url.Values{}
 	if tw.Name != asana.Name {
-		v.Add("name", tw.Name)
+		v.Add("name", transformName(ToAsana, tw.Name))
+	}
+	if tw.Notes != asana.Notes {
+		v.Add("notes", tw.Notes)
+	}
+	wid, _ := cache.FindProject(asana.Project)
+	if tw.Priority != asana.Priority && !(tw.Priority == "" && asana.Priority == x.UnmappedPriority) {
+		addPriority(v, wid, tw.Priority)
+	}
+	if wid != "" {
+		addCustomFields(v, wid, tw.CustomFields, asana.CustomFields)
 	}
+	updateDate(v, "due", tw.Due, asana.Due)
+	updateDate(v, "start", tw.Start, asana.Start)
 	if tw.Assignee != asana.Assignee {
-		a := cache.UserId(tw.Assignee)
-		if a != "" {
+		wid, _ := cache.FindProject(asana.Project)
+		if tw.Assignee == "" {
+			// Unassigned in Taskwarrior since the last sync.
+			v.Add("assignee", "null")
+		} else if a := cache.WorkspaceUserId(wid, tw.Assignee); a != "" {
 			v.Add("assignee", a)
 		}
 	}
+	if tw.AssigneeStatus != asana.AssigneeStatus && tw.AssigneeStatus != "" && tw.Assignee != "" {
+		if err := checkAssigneeStatus(tw.AssigneeStatus); err != nil {
+			return errors.Wrap(err, "asana.UpdateTask")
+		}
+		v.Add("assignee_status", tw.AssigneeStatus)
+	}
 	if !tw.Completed.IsZero() && asana.Completed.IsZero() {
 		v.Add("completed", "true")
 	} else if !asana.Completed.IsZero() && tw.Completed.IsZero() {
@@ -409,56 +1224,115 @@ func UpdateTask(tw x.WarriorTask, asana x.WarriorTask) error {
 	}
 
 	if len(v) > 0 {
-		resp, err := runPost("PUT", "tasks/"+tw.Xid, v)
+		resp, err := runPost(ctx, "PUT", "tasks/"+tw.Xid, v)
 		if err != nil {
 			return errors.Wrap(err, "UpdateAsanaTask")
 		}
-		fmt.Println(string(resp))
+		logger.Debugf("%s", resp)
 	}
 
-	if err := updateTags(tw, asana); err != nil {
+	if err := updateTags(ctx, wid, tw, asana); err != nil {
 		return errors.Wrap(err, "asana.UpdateTask updateTags")
 	}
+	if err := updateFollowers(ctx, tw, asana); err != nil {
+		return errors.Wrap(err, "asana.UpdateTask updateFollowers")
+	}
+	if err := updateDependencies(ctx, tw, asana); err != nil {
+		return errors.Wrap(err, "asana.UpdateTask updateDependencies")
+	}
+	if err := updateProjects(ctx, tw, asana); err != nil {
+		return errors.Wrap(err, "asana.UpdateTask updateProjects")
+	}
+	if tw.Parent != asana.Parent && tw.Parent != x.PendingParent {
+		if err := SetParent(ctx, tw.Xid, tw.Parent); err != nil {
+			return errors.Wrap(err, "asana.UpdateTask SetParent")
+		}
+	}
 
 	// Update project or section if changed.
-	pid := cache.ProjectId(tw.Project)
-	if pid != "" && (tw.Project != asana.Project || tw.Section != asana.Section) {
-		fmt.Printf("Updating project and section: %v %v\n", tw.Project, tw.Section)
-		if err := updateSection(tw.Xid, pid, tw.Section); err != nil {
-			return errors.Wrap(err, "asana.UpdateTask updateSection")
-		}
-		if tw.Project == asana.Project {
-			return nil
-		}
-		// Project was changed. So, remove the last one.
-		fmt.Printf("Removing from project: %v\n", asana.Project)
-		if previd := cache.ProjectId(asana.Project); previd != "" {
-			if err := removeProject(tw.Xid, previd); err != nil {
-				return err
-			}
+	if tw.Project == asana.Project && tw.Section == asana.Section {
+		return nil
+	}
+	_, pid, err := resolveProject(ctx, tw.Project)
+	if err != nil {
+		return errors.Wrap(err, "asana.UpdateTask")
+	}
+	if pid == "" {
+		logger.Warnf("Project %q of task %q not found in Asana. Leaving the task in %q.",
+			tw.Project, tw.Name, asana.Project)
+		return nil
+	}
+	logger.Infof("Updating project and section: %v %v", tw.Project, tw.Section)
+	if err := updateSection(ctx, tw.Xid, pid, tw.Section); err != nil {
+		return errors.Wrap(err, "asana.UpdateTask updateSection")
+	}
+	if tw.Project == asana.Project {
+		return nil
+	}
+	// Project was changed. So, remove the last one.
+	logger.Infof("Removing from project: %v", asana.Project)
+	if _, previd := cache.FindProject(asana.Project); previd != "" {
+		if err := removeProject(ctx, tw.Xid, previd); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func GetOneTask(taskid string) (x.WarriorTask, error) {
+// Exists returns whether the task still exists in Asana. Deleted tasks aren't part of
+// any listing, so they can only be told apart from tasks which aren't being synced by
+// asking for them directly. Tasks of archived projects are known to exist from their
+// listing.
+func Exists(ctx context.Context, taskid string) (bool, error) {
+	archivedTasks.Lock()
+	archived := archivedTasks.ids[taskid]
+	archivedTasks.Unlock()
+	if archived {
+		return true, nil
+	}
+
+	var ot oneTask
+	err := runGetter(ctx, &ot, "tasks/"+taskid, "gid")
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Exists")
+	}
+	return true, nil
+}
+
+func GetOneTask(ctx context.Context, taskid string) (x.WarriorTask, error) {
 	e := x.WarriorTask{}
 	var ot oneTask
-	if err := runGetter(&ot, "tasks/"+taskid); err != nil {
+	if err := runGetter(ctx, &ot, "tasks/"+taskid); err != nil {
 		return e, errors.Wrap(err, "AddNew runGetter")
 	}
 
 	if len(ot.Data.Memberships) == 0 {
 		return e, errors.New("Member of no project")
 	}
-	member := ot.Data.Memberships[0]
+	member, ok := primaryMembership(ot.Data)
+	if !ok {
+		member = ot.Data.Memberships[0]
+	}
 
-	sname := cache.SectionName(member.Project.Id, member.Section.Id)
-	return convert(ot.Data, member.Project.Name, sname)
+	sname := cache.AddSectionObject(member.Project.Id, member.Section)
+	if sname == "" {
+		sname = cache.SectionName(member.Project.Id, member.Section.Id)
+	}
+	wt, err := convert(ot.Data, member.Project.Name, sname)
+	if err != nil {
+		return e, err
+	}
+	if err := addComments(ctx, &wt); err != nil {
+		return e, errors.Wrap(err, "GetOneTask addComments")
+	}
+	return wt, nil
 }
 
-func Delete(taskid string) error {
+func Delete(ctx context.Context, taskid string) error {
 	url := fmt.Sprintf("%s/tasks/%s", prefix, taskid)
-	_, err := runRequest("DELETE", url)
+	_, err := runRequest(ctx, "DELETE", url)
 	return err
 }
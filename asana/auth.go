@@ -0,0 +1,49 @@
+package asana
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// TokenSource supplies the bearer token attached to every Asana request. It's asked for
+// the token on each call, so it can refresh it transparently. An oauth2.TokenSource can
+// be adapted by returning the AccessToken of its current Token.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticToken returns a TokenSource which always supplies tok, e.g. a personal access
+// token.
+func StaticToken(tok string) TokenSource {
+	return staticToken(tok)
+}
+
+type staticToken string
+
+func (t staticToken) Token() (string, error) { return string(t), nil }
+
+var (
+	authMu      sync.RWMutex
+	tokenSource TokenSource
+)
+
+// SetTokenSource makes requests authenticate via ts. A nil TokenSource restores the
+// default, which is the personal access token passed via -token.
+func SetTokenSource(ts TokenSource) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	tokenSource = ts
+}
+
+// bearer returns the token to authenticate the next request with.
+func bearer() (string, error) {
+	authMu.RLock()
+	ts := tokenSource
+	authMu.RUnlock()
+	if ts == nil {
+		return *token, nil
+	}
+	tok, err := ts.Token()
+	return tok, errors.Wrap(err, "bearer")
+}
@@ -1,212 +1,1372 @@
 package asana
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
 )
 
 type asection struct {
-	list []Basic
+	list     []Basic           // Normalized names.
+	original map[string]string // Names as in Asana, by section id.
+}
+
+// setOriginal records the name of the section as in Asana.
+func (s *asection) setOriginal(id, name string) {
+	if s.original == nil {
+		s.original = make(map[string]string)
+	}
+	s.original[id] = name
+}
+
+// wcache holds the projects, tags and users of a single workspace.
+type wcache struct {
+	projects   []Basic
+	tags       []Basic
+	users      []Basic
+	projectmap map[string]string
+	tagmap     map[string]string
+	usermap    map[string]string
+	// Teams only exist in organizations.
+	teams       []Basic
+	projectteam map[string]string // Project id to team id.
+	// Only retrieved if any custom fields are synced, via -udas.
+	customFields []customField
+	optionmap    map[string]string // Enum option id to name.
 }
 
 type acache struct {
 	sync.RWMutex
+	client      AsanaClient
 	workspaces  []Basic
 	defaultWork string
-	projects    []Basic
-	tags        []Basic
-	users       []Basic
-	tagmap      map[string]string
-	usermap     map[string]string
+	resolved    map[string]string  // Workspace names passed via -domain, to their ids.
+	spaces      map[string]*wcache // Keyed by workspace id.
+	// Sections are keyed by project id. Those are unique across workspaces, so there's
+	// no need to scope sections by workspace.
 	sections    map[string]*asection
+	missing     map[string]bool // Projects not found even after refreshing, until the next update.
+	lastUpdated time.Time
+	loaded      bool // Loaded from disk, and not updated from Asana since.
+	observers   []func(kind string, added []Basic)
+	pending     []change
+}
+
+func printBasics(title string, bs []Basic) {
+	if !*verbose {
+		return // Avoid unnecessary output. Useful for debugging.
+	}
+
+	for _, b := range bs {
+		if len(b.Email) > 0 {
+			logger.Debugf("%9s %16s %s", title, b.Id, b.Email)
+		} else {
+			logger.Debugf("%9s %16s %s", title, b.Id, b.Name)
+		}
+	}
+}
+
+// sortByName sorts the entries by name, ignoring case, so their order stays the same
+// across updates. Entries with the same name keep the order Asana sent them in, so the
+// same one of them keeps getting used.
+func sortByName(bs []Basic) {
+	sort.SliceStable(bs, func(i, j int) bool {
+		return strings.ToLower(bs[i].Name) < strings.ToLower(bs[j].Name)
+	})
+}
+
+// domains returns the workspace names passed via -domain. The first one is the default.
+func domains() []string {
+	var names []string
+	for _, name := range strings.Split(*domain, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// updateProjects updates the projects. w must not be shared with other goroutines yet.
+func (w *wcache) updateProjects(ctx context.Context, api AsanaClient, wid string) error {
+	var err error
+	w.projects, err = api.Get(ctx, "workspaces/"+wid+"/projects", "name", "archived")
+	if err != nil {
+		return err
+	}
+	if err := w.updateTeams(ctx, api, wid); err != nil {
+		return err
+	}
+	sortByName(w.projects)
+	w.projectmap = make(map[string]string)
+	for _, p := range w.projects {
+		w.projectmap[p.Id] = p.Name
+	}
+	printBasics("Project", w.projects)
+	return nil
+}
+
+// updateTeams retrieves the teams of the workspace, and adds the projects of each team
+// which weren't already listed for the workspace.
+func (w *wcache) updateTeams(ctx context.Context, api AsanaClient, wid string) error {
+	w.teams = nil
+	w.projectteam = make(map[string]string)
+	teams, err := api.Get(ctx, "workspaces/"+wid+"/teams", "name")
+	if hasStatus(err, http.StatusBadRequest) {
+		// Not an organization.
+		return nil
+	}
+	if err != nil {
+		logger.Warnf("Unable to list the teams of workspace %v: %v", wid, err)
+		return nil
+	}
+	w.teams = teams
+
+	seen := make(map[string]bool, len(w.projects))
+	for _, p := range w.projects {
+		seen[p.Id] = true
+	}
+	for _, t := range teams {
+		projects, err := api.Get(ctx, "teams/"+t.Id+"/projects", "name", "archived")
+		if err != nil {
+			return errors.Wrapf(err, "team %q", t.Name)
+		}
+		for _, p := range projects {
+			w.projectteam[p.Id] = t.Id
+			if !seen[p.Id] {
+				seen[p.Id] = true
+				w.projects = append(w.projects, p)
+			}
+		}
+	}
+	printBasics("Team", w.teams)
+	return nil
+}
+
+// updateTags updates the tags. w must not be shared with other goroutines yet.
+func (w *wcache) updateTags(ctx context.Context, api AsanaClient, wid string) error {
+	var err error
+	w.tags, err = api.Get(ctx, "workspaces/"+wid+"/tags", "name")
+	if err != nil {
+		return err
+	}
+	sortByName(w.tags)
+	w.tagmap = make(map[string]string)
+	for _, t := range w.tags {
+		w.tagmap[t.Id] = t.Name
+	}
+	printBasics("Tag", w.tags)
+	return nil
+}
+
+// updateUsers updates the users. w must not be shared with other goroutines yet.
+func (w *wcache) updateUsers(ctx context.Context, api AsanaClient, wid string) error {
+	var err error
+	w.users, err = api.Get(ctx, "workspaces/"+wid+"/users", "email", "name")
+	if errors.Is(err, ErrForbidden) {
+		// Some accounts can't list users. Sync everything else, without assignees.
+		logger.Warnf("Not allowed to list the users of workspace %v. Assignees won't be synced.", wid)
+		w.users = nil
+		w.usermap = make(map[string]string)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range w.users {
+		u := &w.users[i]
+		email := strings.Split(u.Email, "@")
+		u.FullEmail = u.Email
+		u.Email = email[0]
+	}
+	sortByName(w.users)
+	w.usermap = make(map[string]string)
+	for _, u := range w.users {
+		w.usermap[u.Id] = u.Email
+	}
+	printBasics("User", w.users)
+	return nil
+}
+
+// updateCustomFields updates the custom fields, if any of them are synced. w must not
+// be shared with other goroutines yet.
+func (w *wcache) updateCustomFields(ctx context.Context, wid string) error {
+	w.optionmap = make(map[string]string)
+	if len(udaMap()) == 0 {
+		return nil
+	}
+	var err error
+	w.customFields, err = getCustomFields(ctx, wid)
+	if err != nil {
+		return err
+	}
+	for _, cf := range w.customFields {
+		for _, o := range cf.EnumOptions {
+			w.optionmap[o.Id] = o.Name
+		}
+	}
+	return nil
+}
+
+// update retrieves the projects, tags and users of workspace wid concurrently, since
+// they don't depend upon each other. It returns the first error encountered. Each
+// fetch touches its own fields, but w must not be shared with other goroutines yet.
+func (w *wcache) update(ctx context.Context, api AsanaClient, wid string) error {
+	errc := make(chan error, 4)
+	go func() {
+		errc <- errors.Wrap(w.updateProjects(ctx, api, wid), "projects")
+	}()
+	go func() {
+		errc <- errors.Wrap(w.updateTags(ctx, api, wid), "tags")
+	}()
+	go func() {
+		errc <- errors.Wrap(w.updateUsers(ctx, api, wid), "users")
+	}()
+	go func() {
+		errc <- errors.Wrap(w.updateCustomFields(ctx, wid), "custom fields")
+	}()
+
+	var rerr error
+	for i := 0; i < 4; i++ {
+		if err := <-errc; err != nil && rerr == nil {
+			rerr = err
+		}
+	}
+	return rerr
+}
+
+func (c *acache) update(ctx context.Context) error {
+	if err := c.UpdateContext(ctx); err != nil {
+		return err
+	}
+	if *verifyCache {
+		_, err := c.VerifyCounts(ctx)
+		return err
+	}
+	return nil
+}
+
+// UpdateContext refreshes the cache from Asana. Cancelling ctx aborts any in-flight
+// request, and returns ctx.Err(). The lock is only held to swap in the results, so the
+// cache keeps serving lookups while Asana is being queried.
+func (c *acache) UpdateContext(ctx context.Context) error {
+	defer c.notifyChanges()
+	c.RLock()
+	api := c.api()
+	resolved := c.resolved
+	c.RUnlock()
+
+	workspaces, err := api.Get(ctx, "workspaces", "name")
+	if err != nil {
+		return errors.Wrap(err, "workspaces")
+	}
+	printBasics("Workspace", workspaces)
+	if len(workspaces) == 0 {
+		return ErrNoWorkspaces
+	}
+
+	var defaultWork string
+	spaces := make(map[string]*wcache)
+	ids := make(map[string]string)
+	for i, name := range domains() {
+		var wid string
+		for _, w := range workspaces {
+			if w.Name == name {
+				wid = w.Id
+			}
+		}
+		if wid == "" {
+			wid = renamedWorkspace(name, resolved[name], workspaces)
+		}
+		if wid == "" {
+			return fmt.Errorf("workspace %q not found among %d workspaces", name, len(workspaces))
+		}
+		if i == 0 {
+			defaultWork = wid
+		}
+		ids[name] = wid
+
+		w := new(wcache)
+		if err := w.update(ctx, api, wid); err != nil {
+			return errors.Wrapf(err, "workspace %q", name)
+		}
+		for _, p := range duplicates(w.projects) {
+			logger.Warnf("Multiple projects named %q in workspace %q. Only the first one is used.",
+				p, name)
+		}
+		for _, t := range duplicates(w.tags) {
+			logger.Warnf("Multiple tags named %q in workspace %q. Only the first one is used.",
+				t, name)
+		}
+		spaces[wid] = w
+	}
+	if len(spaces) == 0 {
+		return fmt.Errorf("workspace %q not found among %d workspaces", *domain, len(workspaces))
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for wid, w := range spaces {
+		prev := c.space(wid)
+		c.recordChange("project", newEntries(prev.projects, w.projects))
+		c.recordChange("tag", newEntries(prev.tags, w.tags))
+		c.recordChange("user", newEntries(prev.users, w.users))
+		// The priority field is learnt from tasks, unless it was retrieved along with the
+		// other custom fields.
+		if cf, has := prev.customField(*priorityField); has {
+			if _, found := w.customField(*priorityField); !found {
+				w.customFields = append(w.customFields, cf)
+			}
+		}
+	}
+	c.workspaces = workspaces
+	c.defaultWork = defaultWork
+	c.resolved = ids
+	c.spaces = spaces
+	// Sections aren't part of the update. Only drop the ones of projects which are gone.
+	for pid := range c.sections {
+		if c.projectSpace(pid) == nil {
+			delete(c.sections, pid)
+		}
+	}
+	c.missing = nil
+	c.lastUpdated = time.Now()
+	c.loaded = false
+	return nil
+}
+
+// updateStale updates the cache like update, unless it was loaded from disk and not
+// updated since. Then, it's served as is, while being updated in the background.
+func (c *acache) updateStale(ctx context.Context) error {
+	c.Lock()
+	loaded := c.loaded
+	c.loaded = false
+	c.Unlock()
+	if !loaded {
+		return c.update(ctx)
+	}
+
+	go func() {
+		if err := c.update(ctx); err != nil && ctx.Err() == nil {
+			logger.Errorf("Background update of the cache loaded from disk failed: %+v", err)
+		}
+	}()
+	return nil
+}
+
+// renamedWorkspace returns prevId, the id previously resolved for the workspace name, if
+// that workspace still exists under a different name. This keeps syncs going across a
+// rename.
+func renamedWorkspace(name, prevId string, cur []Basic) string {
+	if prevId == "" {
+		return ""
+	}
+	for _, w := range cur {
+		if w.Id == prevId {
+			logger.Warnf("Workspace %q has been renamed to %q. Please update -domain.",
+				name, w.Name)
+			return w.Id
+		}
+	}
+	return ""
+}
+
+// duplicates returns the names shared by more than one entry, in order.
+func duplicates(bs []Basic) []string {
+	count := make(map[string]int, len(bs))
+	var dups []string
+	for _, b := range bs {
+		count[b.Name]++
+		if count[b.Name] == 2 {
+			dups = append(dups, b.Name)
+		}
+	}
+	return dups
+}
+
+// AmbiguousProjects returns the project names shared by multiple projects of a workspace.
+// Looking these up by name picks the first one, which might not be the intended one.
+func (c *acache) AmbiguousProjects() []string {
+	c.RLock()
+	defer c.RUnlock()
+	var names []string
+	for _, w := range c.spaces {
+		names = append(names, duplicates(w.projects)...)
+	}
+	return names
+}
+
+// AmbiguousTags returns the tag names shared by multiple tags of a workspace.
+func (c *acache) AmbiguousTags() []string {
+	c.RLock()
+	defer c.RUnlock()
+	var names []string
+	for _, w := range c.spaces {
+		names = append(names, duplicates(w.tags)...)
+	}
+	return names
+}
+
+// Reset empties the cache, as if it was never updated. Observers and the client are kept,
+// but changes they haven't been notified of yet are dropped.
+func (c *acache) Reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.workspaces = nil
+	c.defaultWork = ""
+	c.resolved = nil
+	c.spaces = nil
+	c.sections = nil
+	c.missing = nil
+	c.lastUpdated = time.Time{}
+	c.loaded = false
+	c.pending = nil
+}
+
+// LastUpdated returns when the cache was last successfully updated from Asana.
+func (c *acache) LastUpdated() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lastUpdated
+}
+
+// CacheStats holds the number of cached entities, summed over all workspaces.
+type CacheStats struct {
+	Workspaces  int
+	Projects    int
+	Tags        int
+	Users       int
+	Sections    int
+	LastUpdated time.Time
+}
+
+// Stats returns the number of entities currently in the cache.
+func (c *acache) Stats() CacheStats {
+	c.RLock()
+	defer c.RUnlock()
+	st := CacheStats{
+		Workspaces:  len(c.workspaces),
+		LastUpdated: c.lastUpdated,
+	}
+	for _, w := range c.spaces {
+		st.Projects += len(w.projects)
+		st.Tags += len(w.tags)
+		st.Users += len(w.users)
+	}
+	for _, s := range c.sections {
+		st.Sections += len(s.list)
+	}
+	return st
+}
+
+// StartAutoRefresh updates the cache every interval in the background, until stop is
+// closed. Errors are logged, and the next refresh is attempted as usual. Closing stop
+// also cancels any update in flight.
+func (c *acache) StartAutoRefresh(interval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.UpdateContext(ctx); err != nil && ctx.Err() == nil {
+					logger.Errorf("Cache auto refresh failed: %+v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// api returns the client to talk to Asana with. Appropriate locks should be acquired
+// by the caller.
+func (c *acache) api() AsanaClient {
+	if c.client == nil {
+		return apiClient{}
+	}
+	return c.client
+}
+
+// space returns the cache for workspace wid. It's never nil, so lookups on a workspace
+// which isn't cached just come back empty. Appropriate locks should be acquired by the
+// caller.
+func (c *acache) space(wid string) *wcache {
+	if w, has := c.spaces[wid]; has {
+		return w
+	}
+	return new(wcache)
+}
+
+// writableSpace is like space, but fails if the workspace isn't cached, so new entries
+// don't silently get lost. Appropriate locks should be acquired by the caller.
+func (c *acache) writableSpace(wid string) (*wcache, error) {
+	w, has := c.spaces[wid]
+	if !has {
+		return nil, fmt.Errorf("Workspace not found in cache: %q", wid)
+	}
+	return w, nil
+}
+
+// Workspace returns the id of the default workspace.
+func (c *acache) Workspace() string {
+	return c.DefaultWorkspace()
+}
+
+// DefaultWorkspace returns the id of the first workspace passed via -domain.
+func (c *acache) DefaultWorkspace() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.defaultWork
+}
+
+// DefaultWorkspaceName returns the name of the default workspace.
+func (c *acache) DefaultWorkspaceName() string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, w := range c.workspaces {
+		if w.Id == c.defaultWork {
+			return w.Name
+		}
+	}
+	return ""
+}
+
+// Workspaces returns all the workspaces which are being synced, default first.
+func (c *acache) Workspaces() []Basic {
+	c.RLock()
+	defer c.RUnlock()
+	var ws []Basic
+	for _, w := range c.workspaces {
+		if _, has := c.spaces[w.Id]; !has {
+			continue
+		}
+		if w.Id == c.defaultWork {
+			ws = append([]Basic{w}, ws...)
+		} else {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+// Projects returns the projects in the default workspace.
+func (c *acache) Projects() []Basic {
+	return c.WorkspaceProjects(c.DefaultWorkspace())
+}
+
+func (c *acache) WorkspaceProjects(wid string) []Basic {
+	c.RLock()
+	defer c.RUnlock()
+	w := c.space(wid)
+	projects := make([]Basic, len(w.projects))
+	copy(projects, w.projects)
+	return projects
+}
+
+// AllProjects returns the projects across all the synced workspaces.
+func (c *acache) AllProjects() []Basic {
+	var projects []Basic
+	for _, w := range c.Workspaces() {
+		projects = append(projects, c.WorkspaceProjects(w.Id)...)
+	}
+	return projects
+}
+
+// ActiveProjects is like AllProjects, leaving out the archived projects.
+func (c *acache) ActiveProjects() []Basic {
+	var active []Basic
+	for _, p := range c.AllProjects() {
+		if !p.Archived {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+// IsArchived returns true if the project is cached as archived in Asana.
+func (c *acache) IsArchived(projId string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, w := range c.spaces {
+		for _, p := range w.projects {
+			if p.Id == projId {
+				return p.Archived
+			}
+		}
+	}
+	return false
+}
+
+// ProjectId returns the id of the named project in the default workspace.
+func (c *acache) ProjectId(name string) string {
+	return c.WorkspaceProjectId(c.DefaultWorkspace(), name)
+}
+
+// ProjectIdOrRefresh is like ProjectId, but if the project isn't found, the projects of
+// the default workspace are retrieved again before trying once more. This picks up
+// projects created since the last update. Projects still not found aren't looked for
+// again until the next update.
+func (c *acache) ProjectIdOrRefresh(ctx context.Context, name string) (string, error) {
+	if id := c.ProjectId(name); id != "" {
+		return id, nil
+	}
+	c.RLock()
+	missing := c.missing[name]
+	c.RUnlock()
+	if missing {
+		return "", nil
+	}
+
+	defer c.notifyChanges()
+	c.RLock()
+	api, wid := c.api(), c.defaultWork
+	c.RUnlock()
+
+	fresh := new(wcache)
+	if err := fresh.updateProjects(ctx, api, wid); err != nil {
+		return "", errors.Wrap(err, "ProjectIdOrRefresh")
+	}
+
+	c.Lock()
+	w, err := c.writableSpace(wid)
+	if err != nil {
+		c.Unlock()
+		return "", errors.Wrap(err, "ProjectIdOrRefresh")
+	}
+	c.setProjects(w, fresh)
+	c.Unlock()
+
+	id := c.ProjectId(name)
+	if id == "" {
+		c.Lock()
+		if c.missing == nil {
+			c.missing = make(map[string]bool)
+		}
+		c.missing[name] = true
+		c.Unlock()
+	}
+	return id, nil
+}
+
+func (c *acache) WorkspaceProjectId(wid, name string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, p := range c.space(wid).projects {
+		if p.Name == name {
+			return p.Id
+		}
+	}
+	return ""
+}
+
+// FindProject returns the workspace and id of the named project, looking in the default
+// workspace first.
+func (c *acache) FindProject(name string) (string, string) {
+	for _, w := range c.Workspaces() {
+		if pid := c.WorkspaceProjectId(w.Id, name); pid != "" {
+			return w.Id, pid
+		}
+	}
+	return "", ""
+}
+
+// ProjectWorkspace returns the id of the workspace the project belongs to.
+func (c *acache) ProjectWorkspace(pid string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for wid, w := range c.spaces {
+		if _, has := w.projectmap[pid]; has {
+			return wid
+		}
+	}
+	return ""
+}
+
+// ProjectTeam returns the id of the team the project belongs to, if any.
+func (c *acache) ProjectTeam(pid string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, w := range c.spaces {
+		if tid, has := w.projectteam[pid]; has {
+			return tid
+		}
+	}
+	return ""
+}
+
+// ProjectsByTeam returns the projects of all workspaces, keyed by the name of the team
+// they belong to. Projects without a team are keyed by "".
+func (c *acache) ProjectsByTeam() map[string][]Basic {
+	c.RLock()
+	defer c.RUnlock()
+	groups := make(map[string][]Basic)
+	for _, w := range c.spaces {
+		teams := make(map[string]string, len(w.teams))
+		for _, t := range w.teams {
+			teams[t.Id] = t.Name
+		}
+		for _, p := range w.projects {
+			name := teams[w.projectteam[p.Id]]
+			groups[name] = append(groups[name], p)
+		}
+	}
+	return groups
+}
+
+// ProjectIdFold is like ProjectId, but ignores case and surrounding whitespace. If
+// multiple projects match, the first one in cache order is returned.
+func (c *acache) ProjectIdFold(name string) string {
+	c.RLock()
+	defer c.RUnlock()
+	name = strings.TrimSpace(name)
+	for _, p := range c.space(c.defaultWork).projects {
+		if strings.EqualFold(strings.TrimSpace(p.Name), name) {
+			return p.Id
+		}
+	}
+	return ""
+}
+
+// ProjectName returns the name of the project, from whichever workspace it's in.
+func (c *acache) ProjectName(id string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, w := range c.spaces {
+		if name, has := w.projectmap[id]; has {
+			return name
+		}
+	}
+	return ""
+}
+
+// Users returns a copy of the users in the default workspace. Email holds the short
+// name used as the Taskwarrior assignee.
+func (c *acache) Users() []Basic {
+	c.RLock()
+	defer c.RUnlock()
+	w := c.space(c.defaultWork)
+	users := make([]Basic, len(w.users))
+	copy(users, w.users)
+	return users
+}
+
+// User returns the short email of the user, from whichever workspace they're in.
+func (c *acache) User(uid string) string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.user(uid)
+}
+
+// UserMap returns a copy of the user id to short email mapping, across the synced
+// workspaces, for resolving many ids without taking the lock for each one.
+func (c *acache) UserMap() map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+	m := make(map[string]string)
+	for _, w := range c.spaces {
+		for id, name := range w.usermap {
+			m[id] = name
+		}
+	}
+	return m
+}
+
+// UserNames resolves the user ids in one go, preserving their order. Unknown ids
+// resolve to "".
+func (c *acache) UserNames(ids []string) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = c.user(id)
+	}
+	return names
+}
+
+func (c *acache) user(uid string) string {
+	for _, w := range c.spaces {
+		if email, has := w.usermap[uid]; has {
+			return email
+		}
+	}
+	return ""
+}
+
+// UserId returns the id of the user in the default workspace.
+func (c *acache) UserId(email string) string {
+	return c.WorkspaceUserId(c.DefaultWorkspace(), email)
+}
+
+func (c *acache) WorkspaceUserId(wid, email string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, u := range c.space(wid).users {
+		if email == u.Email {
+			return u.Id
+		}
+	}
+	return ""
+}
+
+// UserIdByEmail returns the id of the user in the default workspace, matching on the
+// full email address. Unlike UserId, this can tell apart users sharing the same name
+// across email domains.
+func (c *acache) UserIdByEmail(fullEmail string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, u := range c.space(c.defaultWork).users {
+		if strings.EqualFold(fullEmail, u.FullEmail) {
+			return u.Id
+		}
+	}
+	return ""
+}
+
+// UserIdByName returns the id of the user in the default workspace, matching on the
+// display name, ignoring case.
+func (c *acache) UserIdByName(name string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, u := range c.space(c.defaultWork).users {
+		if strings.EqualFold(name, u.Name) {
+			return u.Id
+		}
+	}
+	return ""
+}
+
+// Tags returns a copy of the tags in the default workspace.
+func (c *acache) Tags() []Basic {
+	c.RLock()
+	defer c.RUnlock()
+	w := c.space(c.defaultWork)
+	tags := make([]Basic, len(w.tags))
+	copy(tags, w.tags)
+	return tags
+}
+
+// Tag returns the name of the tag, from whichever workspace it's in.
+func (c *acache) Tag(uid string) string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.tag(uid)
+}
+
+// TagMap is like UserMap, for the tag id to name mapping.
+func (c *acache) TagMap() map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+	m := make(map[string]string)
+	for _, w := range c.spaces {
+		for id, name := range w.tagmap {
+			m[id] = name
+		}
+	}
+	return m
+}
+
+// OrphanedTagIds returns the tag ids out of the referenced ones, which aren't cached
+// for any synced workspace, e.g. because the tags were deleted in Asana. Each id is
+// returned once, in the order it was first referenced.
+func (c *acache) OrphanedTagIds(referenced []string) []string {
+	c.RLock()
+	defer c.RUnlock()
+	seen := make(map[string]bool, len(referenced))
+	var orphans []string
+	for _, id := range referenced {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if c.tagSpace(id) == nil {
+			orphans = append(orphans, id)
+		}
+	}
+	return orphans
+}
+
+// TagNames resolves the tag ids in one go, preserving their order. Unknown ids
+// resolve to "".
+func (c *acache) TagNames(ids []string) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = c.tag(id)
+	}
+	return names
+}
+
+func (c *acache) tag(uid string) string {
+	for _, w := range c.spaces {
+		if name, has := w.tagmap[uid]; has {
+			return name
+		}
+	}
+	return ""
+}
+
+// TagId returns the id of the named tag in the default workspace.
+func (c *acache) TagId(tname string) string {
+	return c.WorkspaceTagId(c.DefaultWorkspace(), tname)
+}
+
+func (c *acache) WorkspaceTagId(wid, tname string) string {
+	c.RLock()
+	defer c.RUnlock()
+	for _, t := range c.space(wid).tags {
+		if t.Name == tname {
+			return t.Id
+		}
+	}
+	return ""
+}
+
+// CreateTag creates the tag in the default workspace, unless it's already there.
+func (c *acache) CreateTag(ctx context.Context, tname string) (string, error) {
+	return c.CreateTagIn(ctx, c.DefaultWorkspace(), tname)
+}
+
+// CreateTagIn creates the tag in the workspace, unless it's already there. The workspace
+// must be one of the synced ones.
+func (c *acache) CreateTagIn(ctx context.Context, wid, tname string) (string, error) {
+	defer c.notifyChanges()
+	c.Lock()
+	defer c.Unlock()
+	w, err := c.writableSpace(wid)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateTag")
+	}
+
+	// Just double check after acquiring lock.
+	for _, t := range w.tags {
+		if t.Name == tname {
+			return t.Id, nil
+		}
+	}
+	return c.createTag(ctx, wid, w, tname)
 }
 
-func printBasics(title string, bs []Basic) {
-	return // Avoid unnecessary output. Useful for debugging.
+// EnsureTags returns the ids of the named tags in workspace wid, in the order of their
+// first occurrence in names, creating the missing ones. Duplicate names are only
+// resolved once.
+func (c *acache) EnsureTags(ctx context.Context, wid string, names []string) ([]string, error) {
+	defer c.notifyChanges()
+	c.Lock()
+	defer c.Unlock()
+	w, err := c.writableSpace(wid)
+	if err != nil {
+		return nil, errors.Wrap(err, "EnsureTags")
+	}
 
-	for _, b := range bs {
-		if len(b.Email) > 0 {
-			fmt.Printf("%9s %16s %s\n", title, b.Id, b.Email)
-		} else {
-			fmt.Printf("%9s %16s %s\n", title, b.Id, b.Name)
+	existing := make(map[string]string, len(w.tags))
+	for _, t := range w.tags {
+		existing[t.Name] = t.Id
+	}
+	seen := make(map[string]bool, len(names))
+	var ids []string
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		id, has := existing[name]
+		if !has {
+			if id, err = c.createTag(ctx, wid, w, name); err != nil {
+				return nil, errors.Wrapf(err, "EnsureTags tag: %q", name)
+			}
 		}
+		ids = append(ids, id)
 	}
-	fmt.Println()
+	return ids, nil
 }
 
-// updateTags updates the tags. Appropriate locks should be acquired by the caller.
-func (c *acache) updateTags() error {
-	var err error
-	c.tags, err = getVarious("tags", "name")
+// createTag creates the tag in workspace wid in Asana, and adds it to w, the cache of that
+// workspace. Must be called with the write lock held.
+func (c *acache) createTag(ctx context.Context, wid string, w *wcache,
+	tname string) (string, error) {
+	listPath := "workspaces/" + wid + "/tags"
+	if *checkTags {
+		t, found, err := c.findRemote(ctx, listPath, tname)
+		if err != nil {
+			return "", errors.Wrap(err, "CreateTag check")
+		}
+		if found {
+			logger.Infof("Tag %q already exists in Asana. ID: %s", tname, t.Id)
+			w.tags = append(w.tags, t)
+			w.tagmap[t.Id] = t.Name
+			c.recordChange("tag", []Basic{t})
+			return t.Id, nil
+		}
+	}
+
+	v := url.Values{}
+	v.Add("workspace", wid)
+	v.Add("name", tname)
+	t, err := c.create(ctx, "tags", listPath, tname, v)
 	if err != nil {
-		return err
+		return "", errors.Wrap(err, "CreateTag post")
+	}
+	if t.Id == "" {
+		return "", fmt.Errorf("Unable to find ID assigned by Asana to tag: %q", tname)
+	}
+	w.tags = append(w.tags, t)
+	w.tagmap[t.Id] = t.Name
+	c.recordChange("tag", []Basic{t})
+	logger.Infof("New Tag created. ID: %s", t.Id)
+
+	return t.Id, nil
+}
+
+// tagSpace returns the cache of the workspace holding the tag, or nil if it isn't
+// cached. Appropriate locks should be acquired by the caller.
+func (c *acache) tagSpace(id string) *wcache {
+	for _, w := range c.spaces {
+		if _, has := w.tagmap[id]; has {
+			return w
+		}
 	}
-	c.tagmap = make(map[string]string)
-	for _, t := range c.tags {
-		c.tagmap[t.Id] = t.Name
+	return nil
+}
+
+// projectSpace returns the cache of the workspace holding the project, or nil if it
+// isn't cached. Appropriate locks should be acquired by the caller.
+func (c *acache) projectSpace(pid string) *wcache {
+	for _, w := range c.spaces {
+		if _, has := w.projectmap[pid]; has {
+			return w
+		}
 	}
-	printBasics("Tag", c.tags)
 	return nil
 }
 
-func (c *acache) update() error {
+// DeleteTag deletes the tag from Asana, and from the cache. Deleting a tag which isn't
+// in the cache is a no-op.
+func (c *acache) DeleteTag(ctx context.Context, id string) error {
 	c.Lock()
 	defer c.Unlock()
 
-	var err error
-	c.workspaces, err = getVarious("workspaces", "name")
-	if err != nil {
-		return errors.Wrap(err, "workspaces")
+	w := c.tagSpace(id)
+	if w == nil {
+		return nil
 	}
-	printBasics("Workspace", c.workspaces)
-	for _, w := range c.workspaces {
-		if w.Name == *domain {
-			c.defaultWork = w.Id
+
+	if _, err := c.api().Post(ctx, "DELETE", "tags/"+id, nil); err != nil {
+		return errors.Wrap(err, "DeleteTag post")
+	}
+	for i, t := range w.tags {
+		if t.Id == id {
+			w.tags = append(w.tags[:i], w.tags[i+1:]...)
+			break
 		}
 	}
-	if c.defaultWork == "" {
-		log.Fatalf("Unable to find [%q] domain. Found: %+v", *domain, c.workspaces)
+	delete(w.tagmap, id)
+	return nil
+}
+
+// RenameTag renames the tag in Asana, and in the cache. It fails if the tag isn't in
+// the cache.
+func (c *acache) RenameTag(ctx context.Context, id, newName string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	w := c.tagSpace(id)
+	if w == nil {
+		return fmt.Errorf("Tag not found in cache: %q", id)
+	}
+
+	v := url.Values{}
+	v.Add("name", newName)
+	if _, err := c.api().Post(ctx, "PUT", "tags/"+id, v); err != nil {
+		return errors.Wrap(err, "RenameTag post")
+	}
+	for i := range w.tags {
+		if t := &w.tags[i]; t.Id == id {
+			t.Name = newName
+		}
 	}
+	w.tagmap[id] = newName
+	return nil
+}
 
-	c.projects, err = getVarious("workspaces/"+c.defaultWork+"/projects", "name")
+func (c *acache) CreateProject(ctx context.Context, name string) (string, error) {
+	defer c.notifyChanges()
+	c.Lock()
+	defer c.Unlock()
+	w, err := c.writableSpace(c.defaultWork)
 	if err != nil {
-		return errors.Wrap(err, "projects")
+		return "", errors.Wrap(err, "CreateProject")
 	}
-	printBasics("Project", c.projects)
 
-	if err := c.updateTags(); err != nil {
-		return errors.Wrap(err, "updateTags")
+	// Just double check after acquiring lock.
+	for _, p := range w.projects {
+		if p.Name == name {
+			return p.Id, nil
+		}
 	}
 
-	c.users, err = getVarious("users", "email")
+	v := url.Values{}
+	v.Add("name", name)
+	path := "workspaces/" + c.defaultWork + "/projects"
+	p, err := c.create(ctx, path, path, name, v)
 	if err != nil {
-		return errors.Wrap(err, "users")
-	}
-	for i := range c.users {
-		u := &c.users[i]
-		email := strings.Split(u.Email, "@")
-		u.Email = email[0]
+		return "", errors.Wrap(err, "CreateProject post")
 	}
-	c.usermap = make(map[string]string)
-	for _, u := range c.users {
-		c.usermap[u.Id] = u.Email
+	if p.Id == "" {
+		return "", fmt.Errorf("Unable to find ID assigned by Asana to project: %q", name)
 	}
-	printBasics("User", c.users)
-	c.sections = make(map[string]*asection)
-	return nil
-}
+	w.projects = append(w.projects, p)
+	w.projectmap[p.Id] = p.Name
+	c.recordChange("project", []Basic{p})
 
-func (c *acache) Workspace() string {
-	c.RLock()
-	defer c.RUnlock()
-	return c.defaultWork
+	return p.Id, nil
 }
 
-func (c *acache) Projects() []Basic {
-	c.RLock()
-	defer c.RUnlock()
-	projects := make([]Basic, len(c.projects))
-	copy(projects, c.projects)
-	return projects
+// create POSTs to path, to create the entity with the given name. If the request failed
+// in flight, Asana might have created it regardless. So, the entities listed at listPath
+// are checked for it, before posting again.
+func (c *acache) create(ctx context.Context, path, listPath, name string,
+	v url.Values) (Basic, error) {
+	resp, err := c.api().Post(ctx, "POST", path, v)
+	if errors.Cause(err) == errUnconfirmed {
+		logger.Warnf("Unable to confirm creation of %q: %v. Checking before retrying.", name, err)
+		b, found, lerr := c.findRemote(ctx, listPath, name)
+		if lerr != nil {
+			return Basic{}, errors.Wrapf(lerr, "create check: %q", name)
+		}
+		if found {
+			return b, nil
+		}
+		resp, err = c.api().Post(ctx, "POST", path, v)
+	}
+	if err != nil {
+		return Basic{}, err
+	}
+	var bdo BasicDataOne
+	if err := json.Unmarshal(resp, &bdo); err != nil {
+		return Basic{}, errors.Wrapf(err, "create unmarshal: %q", resp)
+	}
+	if *dryRun && bdo.Data.Id == "" {
+		// Nothing got created, so make up an id for the rest of the run to refer to.
+		return Basic{Id: dryRunPrefix + name, Name: name}, nil
+	}
+	return bdo.Data, nil
 }
 
-func (c *acache) ProjectId(name string) string {
-	c.RLock()
-	defer c.RUnlock()
-	for _, p := range c.projects {
-		if p.Name == name {
-			return p.Id
+// findRemote lists the entities at listPath in Asana, bypassing the cache, and returns
+// the first one with the given name.
+func (c *acache) findRemote(ctx context.Context, listPath, name string) (Basic, bool, error) {
+	list, err := c.api().Get(ctx, listPath, "name")
+	if err != nil {
+		return Basic{}, false, err
+	}
+	for _, b := range list {
+		if b.Name == name {
+			return b, true, nil
 		}
 	}
-	return ""
+	return Basic{}, false, nil
 }
 
-func (c *acache) User(uid string) string {
+// CustomOption returns the name of the enum option, from whichever workspace it's in.
+func (c *acache) CustomOption(id string) string {
 	c.RLock()
 	defer c.RUnlock()
-	return c.usermap[uid]
+	for _, w := range c.spaces {
+		if name, has := w.optionmap[id]; has {
+			return name
+		}
+	}
+	return ""
 }
 
-func (c *acache) UserId(email string) string {
+// CustomFieldValue returns the id of the named custom field in workspace wid, and the
+// value to send to Asana to set it to val. For enum fields, that's the id of the option
+// named val. It returns an empty id if the field isn't known, isn't an enum or text
+// field, or has no such option.
+func (c *acache) CustomFieldValue(wid, name, val string) (string, string) {
 	c.RLock()
 	defer c.RUnlock()
-	for _, u := range c.users {
-		if email == u.Email {
-			return u.Id
+	for _, cf := range c.space(wid).customFields {
+		if cf.Name != name {
+			continue
+		}
+		switch cf.Type {
+		case "text":
+			return cf.Id, val
+		case "enum":
+			if val == "" {
+				return cf.Id, "null"
+			}
+			for _, o := range cf.EnumOptions {
+				if o.Name == val {
+					return cf.Id, o.Id
+				}
+			}
 		}
+		return "", ""
 	}
-	return ""
+	return "", ""
 }
 
-func (c *acache) Tag(uid string) string {
-	c.RLock()
-	defer c.RUnlock()
-	return c.tagmap[uid]
+// customField returns the custom field with the given name. Appropriate locks should be
+// acquired by the caller.
+func (w *wcache) customField(name string) (customField, bool) {
+	for _, cf := range w.customFields {
+		if cf.Name == name {
+			return cf, true
+		}
+	}
+	return customField{}, false
 }
 
-func (c *acache) TagId(tname string) string {
+// SetPriorityField records the custom field holding priorities in workspace wid, as seen
+// on a task. The write lock is only taken if the field or its options changed.
+func (c *acache) SetPriorityField(wid string, cf customField) {
+	cf.TextValue, cf.EnumValue = nil, nil
 	c.RLock()
+	prev, has := c.space(wid).customField(cf.Name)
 	c.RUnlock()
-	for _, t := range c.tags {
-		if t.Name == tname {
-			return t.Id
-		}
+	if has && sameField(prev, cf) {
+		return
 	}
-	return ""
-}
 
-func (c *acache) CreateTag(tname string) string {
 	c.Lock()
 	defer c.Unlock()
+	w, err := c.writableSpace(wid)
+	if err != nil {
+		return
+	}
+	for i := range w.customFields {
+		if w.customFields[i].Name == cf.Name {
+			w.customFields[i] = cf
+			return
+		}
+	}
+	w.customFields = append(w.customFields, cf)
+}
 
-	// Just double check after acquiring lock.
-	for _, t := range c.tags {
-		if t.Name == tname {
-			return t.Id
+// sameField returns whether both custom fields have the same id and options.
+func sameField(a, b customField) bool {
+	if a.Id != b.Id || len(a.EnumOptions) != len(b.EnumOptions) {
+		return false
+	}
+	for i := range a.EnumOptions {
+		if a.EnumOptions[i] != b.EnumOptions[i] {
+			return false
 		}
 	}
+	return true
+}
 
-	v := url.Values{}
-	v.Add("workspace", c.defaultWork)
-	v.Add("name", tname)
-	resp, err := runPost("POST", "tags", v)
-	if err != nil {
+// PriorityOption returns the ids of the priority field in workspace wid, and its option
+// with the given name.
+func (c *acache) PriorityOption(wid, name string) (string, string) {
+	c.RLock()
+	defer c.RUnlock()
+	cf, _ := c.space(wid).customField(*priorityField)
+	for _, o := range cf.EnumOptions {
+		if o.Name == name {
+			return cf.Id, o.Id
+		}
+	}
+	return cf.Id, ""
+}
+
+// AddSection caches a section, which Asana sent us as a task. Only tasks whose name
+// ends in a colon are considered sections. This is the legacy convention, used with
+// -native_sections=false.
+func (c *acache) AddSection(projId string, sec Basic) string {
+	if !strings.HasSuffix(sec.Name, ":") {
 		return ""
 	}
-	var bdo BasicDataOne
-	if err := json.Unmarshal(resp, &bdo); err != nil {
+	return c.addSection(projId, sec)
+}
+
+// AddSectionObject caches a section, which Asana sent us as a section object, e.g. as
+// part of a task membership. Such sections always resolve via SectionName and SectionId,
+// but the name is only returned for tagging tasks with -native_sections, so setups
+// relying on colon suffixed tasks keep working as before.
+func (c *acache) AddSectionObject(projId string, sec Basic) string {
+	if sec.Id == "" || sec.Name == "" {
+		return ""
+	}
+	name := c.addSection(projId, sec)
+	if !*nativeSections {
 		return ""
 	}
-	c.tags = append(c.tags, bdo.Data)
-	c.tagmap[bdo.Data.Id] = bdo.Data.Name
+	return name
+}
 
-	return bdo.Data.Id
+// normalizeSection turns a section name into one usable as a Taskwarrior tag, by
+// dropping whitespace, punctuation and symbols. Letters and digits from any script are
+// kept, so "In Progress:" becomes "InProgress" and "進行中:" stays "進行中".
+func normalizeSection(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) {
+			return r
+		}
+		return -1
+	}, name)
 }
 
-func (c *acache) AddSection(projId string, sec Basic) string {
+// AddSections is like AddSection, for a batch of sections of the same project. The
+// returned names are in the same order, with "" for the ones which aren't sections.
+func (c *acache) AddSections(projId string, secs []Basic) []string {
 	c.Lock()
 	defer c.Unlock()
+	names := make([]string, len(secs))
+	for i, sec := range secs {
+		if strings.HasSuffix(sec.Name, ":") {
+			names[i] = c.addSectionLocked(projId, sec)
+		}
+	}
+	return names
+}
+
+func (c *acache) addSection(projId string, sec Basic) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.addSectionLocked(projId, sec)
+}
+
+// addSectionLocked must be called with the write lock held.
+func (c *acache) addSectionLocked(projId string, sec Basic) string {
+	if c.sections == nil {
+		c.sections = make(map[string]*asection)
+	}
 	s, found := c.sections[projId]
 	if !found {
 		s = new(asection)
 		c.sections[projId] = s
 	}
-	if !strings.HasSuffix(sec.Name, ":") {
-		return ""
-	}
 
-	sec.Name = strings.Map(func(r rune) rune {
-		if 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || '0' <= r && r <= '9' {
-			return r
-		}
-		return -1
-	}, sec.Name)
+	s.setOriginal(sec.Id, sec.Name)
+	sec.Name = normalizeSection(sec.Name)
 
 	for i := range s.list {
 		l := &s.list[i]
@@ -234,6 +1394,139 @@ func (c *acache) SectionName(projId string, secId string) string {
 	return ""
 }
 
+// ListSections returns a copy of the sections cached for the project, in the order
+// they were added. It returns nil if the project has no cached sections.
+func (c *acache) ListSections(projId string) []Basic {
+	c.RLock()
+	defer c.RUnlock()
+	s, found := c.sections[projId]
+	if !found || len(s.list) == 0 {
+		return nil
+	}
+	list := make([]Basic, len(s.list))
+	copy(list, s.list)
+	return list
+}
+
+// FindSection finds a section by name across all projects. If multiple projects have a
+// section with that name, the result is ambiguous. The project whose id sorts first
+// wins, so at least it's always the same one. The name is normalized, like in SectionId.
+func (c *acache) FindSection(name string) (projId, secId string, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	name = normalizeSection(name)
+	pids := make([]string, 0, len(c.sections))
+	for pid := range c.sections {
+		pids = append(pids, pid)
+	}
+	sort.Strings(pids)
+	for _, pid := range pids {
+		for _, l := range c.sections[pid].list {
+			if l.Name == name {
+				return pid, l.Id, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// InvalidateSections replaces the cached sections of the project with the ones currently
+// in Asana, without updating the rest of the cache.
+func (c *acache) InvalidateSections(ctx context.Context, projId string) error {
+	c.RLock()
+	api := c.api()
+	c.RUnlock()
+
+	list, err := api.Get(ctx, "projects/"+projId+"/sections", "name")
+	if err != nil {
+		return errors.Wrapf(err, "InvalidateSections %q", projId)
+	}
+	s := &asection{list: list}
+	for i := range list {
+		s.setOriginal(list[i].Id, list[i].Name)
+		list[i].Name = normalizeSection(list[i].Name)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.sections == nil {
+		c.sections = make(map[string]*asection)
+	}
+	c.sections[projId] = s
+	return nil
+}
+
+// SectionPair is a cached section, with its name both as in Asana and as normalized.
+type SectionPair struct {
+	Id, Original, Normalized string
+}
+
+// SectionPairs returns the sections cached for the project, in the order they were
+// added, to see which normalized name each Asana section got.
+func (c *acache) SectionPairs(projId string) []SectionPair {
+	c.RLock()
+	defer c.RUnlock()
+	s, found := c.sections[projId]
+	if !found {
+		return nil
+	}
+	pairs := make([]SectionPair, 0, len(s.list))
+	for _, l := range s.list {
+		pairs = append(pairs, SectionPair{Id: l.Id, Original: s.original[l.Id], Normalized: l.Name})
+	}
+	return pairs
+}
+
+// MoveTaskToSection moves the task into the section secId of project projId, adding it
+// to the project if needed. The section must be a known section of the project.
+func (c *acache) MoveTaskToSection(ctx context.Context, taskId, projId, secId string) error {
+	c.RLock()
+	api := c.api()
+	s, found := c.sections[projId]
+	valid := false
+	if found {
+		for _, l := range s.list {
+			if l.Id == secId {
+				valid = true
+				break
+			}
+		}
+	}
+	c.RUnlock()
+	if !valid {
+		return errors.Errorf("Section %q not found in project %q", secId, projId)
+	}
+
+	v := url.Values{}
+	v.Add("project", projId)
+	v.Add("section", secId)
+	_, err := api.Post(ctx, "POST", fmt.Sprintf("tasks/%s/addProject", taskId), v)
+	return errors.Wrapf(err, "MoveTaskToSection %q", taskId)
+}
+
+// CompleteTask marks the task as completed in Asana.
+func (c *acache) CompleteTask(ctx context.Context, taskId string) error {
+	return errors.Wrapf(c.setCompleted(ctx, taskId, true), "CompleteTask %q", taskId)
+}
+
+// ReopenTask marks the task as incomplete in Asana, which also clears its completed_at.
+func (c *acache) ReopenTask(ctx context.Context, taskId string) error {
+	return errors.Wrapf(c.setCompleted(ctx, taskId, false), "ReopenTask %q", taskId)
+}
+
+func (c *acache) setCompleted(ctx context.Context, taskId string, completed bool) error {
+	c.RLock()
+	api := c.api()
+	c.RUnlock()
+
+	v := url.Values{}
+	v.Add("completed", strconv.FormatBool(completed))
+	_, err := api.Post(ctx, "PUT", "tasks/"+taskId, v)
+	return err
+}
+
+// SectionId returns the id of the named section of the project. The name is normalized
+// like the cached ones, so "In Progress:" finds the section stored as "InProgress".
 func (c *acache) SectionId(projId string, sectionName string) string {
 	c.RLock()
 	defer c.RUnlock()
@@ -241,6 +1534,7 @@ func (c *acache) SectionId(projId string, sectionName string) string {
 	if !found {
 		return ""
 	}
+	sectionName = normalizeSection(sectionName)
 	for _, l := range s.list {
 		if l.Name == sectionName {
 			return l.Id
@@ -248,3 +1542,152 @@ func (c *acache) SectionId(projId string, sectionName string) string {
 	}
 	return ""
 }
+
+// cacheSpace is the JSON representation of wcache.
+type cacheSpace struct {
+	Projects   []Basic           `json:"projects"`
+	Tags       []Basic           `json:"tags"`
+	Users      []Basic           `json:"users"`
+	ProjectMap map[string]string `json:"project_map"`
+	TagMap     map[string]string `json:"tag_map"`
+	UserMap    map[string]string `json:"user_map"`
+
+	Teams       []Basic           `json:"teams"`
+	ProjectTeam map[string]string `json:"project_team"`
+
+	CustomFields []customField     `json:"custom_fields"`
+	OptionMap    map[string]string `json:"option_map"`
+}
+
+// cacheFile is the JSON representation of acache, as stored on disk.
+type cacheFile struct {
+	Workspaces  []Basic               `json:"workspaces"`
+	DefaultWork string                `json:"default_workspace"`
+	Resolved    map[string]string     `json:"resolved_workspaces"`
+	Spaces      map[string]cacheSpace `json:"spaces"`
+	Sections    map[string][]Basic    `json:"sections"` // Names as in Asana.
+	LastUpdated time.Time             `json:"last_updated"`
+}
+
+// Save writes the cache to path as JSON.
+func (c *acache) Save(path string) error {
+	c.RLock()
+	defer c.RUnlock()
+
+	cf := cacheFile{
+		Workspaces:  c.workspaces,
+		DefaultWork: c.defaultWork,
+		Resolved:    c.resolved,
+		Spaces:      make(map[string]cacheSpace),
+		Sections:    c.exportSections(),
+		LastUpdated: c.lastUpdated,
+	}
+	for wid, w := range c.spaces {
+		cf.Spaces[wid] = cacheSpace{
+			Projects:   w.projects,
+			Tags:       w.tags,
+			Users:      w.users,
+			ProjectMap: w.projectmap,
+			TagMap:     w.tagmap,
+			UserMap:    w.usermap,
+
+			Teams:       w.teams,
+			ProjectTeam: w.projectteam,
+
+			CustomFields: w.customFields,
+			OptionMap:    w.optionmap,
+		}
+	}
+	data, err := json.MarshalIndent(cf, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Save marshal")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, data, 0600), "Save write")
+}
+
+// Load replaces the contents of the cache with the ones stored at path by Save.
+func (c *acache) Load(path string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "Load read")
+	}
+	var cf cacheFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return errors.Wrap(err, "Load unmarshal")
+	}
+
+	c.workspaces = cf.Workspaces
+	c.defaultWork = cf.DefaultWork
+	c.resolved = cf.Resolved
+	c.spaces = make(map[string]*wcache)
+	for wid, cs := range cf.Spaces {
+		c.spaces[wid] = &wcache{
+			projects:   cs.Projects,
+			tags:       cs.Tags,
+			users:      cs.Users,
+			projectmap: cs.ProjectMap,
+			tagmap:     cs.TagMap,
+			usermap:    cs.UserMap,
+
+			teams:       cs.Teams,
+			projectteam: cs.ProjectTeam,
+
+			customFields: cs.CustomFields,
+			optionmap:    cs.OptionMap,
+		}
+	}
+	c.lastUpdated = cf.LastUpdated
+	c.loaded = len(c.spaces) > 0
+	c.importSections(cf.Sections)
+	return nil
+}
+
+// ExportSections returns a copy of the cached sections, by project id, so they can be
+// stored and brought back via ImportSections. The sections keep their names as in Asana.
+func (c *acache) ExportSections() map[string][]Basic {
+	c.RLock()
+	defer c.RUnlock()
+	return c.exportSections()
+}
+
+// exportSections must be called with the read lock held.
+func (c *acache) exportSections() map[string][]Basic {
+	secs := make(map[string][]Basic, len(c.sections))
+	for pid, s := range c.sections {
+		list := make([]Basic, len(s.list))
+		for i, l := range s.list {
+			if name, has := s.original[l.Id]; has {
+				l.Name = name
+			}
+			list[i] = l
+		}
+		secs[pid] = list
+	}
+	return secs
+}
+
+// ImportSections replaces the cached sections with the given ones, by project id. They're
+// looked up by their normalized names, like the ones retrieved from Asana, while their
+// names as given are kept as the original ones.
+func (c *acache) ImportSections(secs map[string][]Basic) {
+	c.Lock()
+	defer c.Unlock()
+	c.importSections(secs)
+}
+
+// importSections must be called with the write lock held.
+func (c *acache) importSections(secs map[string][]Basic) {
+	c.sections = make(map[string]*asection, len(secs))
+	for pid, list := range secs {
+		s := &asection{list: make([]Basic, len(list))}
+		for i, sec := range list {
+			s.setOriginal(sec.Id, sec.Name)
+			sec.Name = normalizeSection(sec.Name)
+			s.list[i] = sec
+		}
+		c.sections[pid] = s
+	}
+}
@@ -0,0 +1,355 @@
+package asana
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubClient is an AsanaClient serving canned entries, keyed by path. Writes are recorded,
+// and creates get an id made up from the name.
+type stubClient struct {
+	mu      sync.Mutex
+	entries map[string][]Basic
+	writes  []string // Method and path of each write.
+	values  []url.Values
+	gets    map[string]int // Number of reads, by path.
+}
+
+func (s *stubClient) Get(ctx context.Context, path string, fields ...string) ([]Basic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gets == nil {
+		s.gets = make(map[string]int)
+	}
+	s.gets[path]++
+	entries, has := s.entries[path]
+	if !has {
+		return nil, fmt.Errorf("stubClient: no entries for %q", path)
+	}
+	return append([]Basic(nil), entries...), nil
+}
+
+func (s *stubClient) Post(ctx context.Context, method, path string, v url.Values) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes = append(s.writes, method+" "+path)
+	s.values = append(s.values, v)
+	return []byte(fmt.Sprintf(`{"data":{"gid":"id-%s","name":%q}}`, v.Get("name"), v.Get("name"))), nil
+}
+
+// useStub makes the cache talk to a stubClient holding a single workspace, for the
+// duration of the test.
+func useStub(t *testing.T) *stubClient {
+	setFlag(t, "domain", "Acme")
+	stub := &stubClient{entries: map[string][]Basic{
+		"workspaces":            {{Id: "1", Name: "Acme"}},
+		"workspaces/1/projects": {{Id: "10", Name: "Work"}},
+		"workspaces/1/teams":    nil,
+		"workspaces/1/tags":     {{Id: "20", Name: "urgent"}},
+		"workspaces/1/users":    {{Id: "30", Name: "Ann", Email: "ann@example.com"}},
+	}}
+	SetClient(stub)
+	t.Cleanup(func() {
+		SetClient(nil)
+		cache.Reset()
+	})
+	return stub
+}
+
+// setFlag sets the flag for the duration of the test.
+func setFlag(t *testing.T, name, value string) {
+	prev := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, prev) })
+}
+
+// TestUpdateConcurrentReads is meant to be run with -race.
+func TestUpdateConcurrentReads(t *testing.T) {
+	useStub(t)
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cache.TagId("urgent")
+				cache.Tag("20")
+				cache.Projects()
+				cache.FindProject("Work")
+				cache.UserId("ann")
+				cache.SectionId("10", "Later")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := cache.update(context.Background()); err != nil {
+			t.Error(err)
+		}
+		if _, err := cache.CreateTag(context.Background(), fmt.Sprintf("tag%d", i)); err != nil {
+			t.Error(err)
+		}
+		cache.AddSection("10", Basic{Id: "40", Name: "Later:"})
+	}
+	close(stop)
+	wg.Wait()
+
+	if id := cache.TagId("urgent"); id != "20" {
+		t.Errorf("TagId(urgent) = %q, want 20", id)
+	}
+	if id := cache.TagId("tag19"); id != "id-tag19" {
+		t.Errorf("TagId(tag19) = %q, want the id of the created tag", id)
+	}
+}
+
+func TestNormalizeSection(t *testing.T) {
+	cases := []struct {
+		name, want string
+	}{
+		{"Later", "Later"},
+		{"In Progress:", "InProgress"},
+		{"To-Do (next week)", "ToDonextweek"},
+		{"進行中:", "進行中"},
+		{"完了 済み", "完了済み"},
+		{"진행 중", "진행중"},
+		{"Café", "Café"},
+		// Combining accents are kept.
+		{"Cafe\u0301 au lait", "Cafe\u0301aulait"},
+		{"À faire", "Àfaire"},
+		{"Größe", "Größe"},
+		{"🔥 Hot", "Hot"},
+		{"Q3 2020", "Q32020"},
+		{"::", ""},
+	}
+	for _, tc := range cases {
+		if got := normalizeSection(tc.name); got != tc.want {
+			t.Errorf("normalizeSection(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSectionIdNormalizesName(t *testing.T) {
+	useStub(t)
+	cache.AddSectionObject("10", Basic{Id: "40", Name: "In Progress"})
+	cache.AddSection("10", Basic{Id: "41", Name: "進行中:"})
+	cache.AddSectionObject("10", Basic{Id: "42", Name: "À faire"})
+
+	cases := []struct {
+		name, want string
+	}{
+		{"InProgress", "40"},
+		{"In Progress", "40"},
+		{"In Progress:", "40"},
+		{"進行中", "41"},
+		{"進行中:", "41"},
+		{"Àfaire", "42"},
+		{"À faire:", "42"},
+		{"Progress", ""},
+		{"", ""},
+	}
+	for _, tc := range cases {
+		if got := cache.SectionId("10", tc.name); got != tc.want {
+			t.Errorf("SectionId(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	if got := cache.SectionId("11", "InProgress"); got != "" {
+		t.Errorf("SectionId in another project = %q", got)
+	}
+}
+
+func TestPriorityFieldPerWorkspace(t *testing.T) {
+	useStub(t)
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	high := Basic{Id: "51", Name: "High"}
+	cache.SetPriorityField("1", customField{Basic: Basic{Id: "50", Name: "Priority"},
+		Type: "enum", EnumValue: &high, EnumOptions: []Basic{high}})
+	cache.SetPriorityField("2", customField{Basic: Basic{Id: "60", Name: "Priority"}})
+
+	if fid, oid := cache.PriorityOption("1", "High"); fid != "50" || oid != "51" {
+		t.Errorf("PriorityOption(1, High) = %q, %q", fid, oid)
+	}
+	if fid, _ := cache.PriorityOption("2", "High"); fid != "" {
+		t.Errorf("Got priority field %q of a workspace which isn't cached", fid)
+	}
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fid, oid := cache.PriorityOption("1", "High"); fid != "50" || oid != "51" {
+		t.Errorf("After update, PriorityOption(1, High) = %q, %q", fid, oid)
+	}
+}
+
+func TestLoadedCacheServedWhileUpdating(t *testing.T) {
+	stub := useStub(t)
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cache.AddSection("10", Basic{Id: "40", Name: "Later:"})
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := cache.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if id := cache.SectionId("10", "Later"); id != "40" {
+		t.Errorf("Section dropped by update. Got id %q", id)
+	}
+
+	// Asana is unreachable, but the stale cache is still served.
+	stub.mu.Lock()
+	delete(stub.entries, "workspaces")
+	before := stub.gets["workspaces"]
+	stub.mu.Unlock()
+	cache.Reset()
+	if err := cache.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.updateStale(context.Background()); err != nil {
+		t.Fatalf("Loaded cache not served: %v", err)
+	}
+	if _, pid := cache.FindProject("Work"); pid != "10" {
+		t.Errorf("FindProject(Work) = %q from the loaded cache", pid)
+	}
+	if err := cache.updateStale(context.Background()); err == nil {
+		t.Error("Only the first update after loading should be in the background")
+	}
+	// Both updates fail at listing the workspaces. Wait for the background one to get
+	// there, so it doesn't outlive the stub.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		stub.mu.Lock()
+		n := stub.gets["workspaces"]
+		stub.mu.Unlock()
+		if n >= before+2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Background update didn't happen")
+		}
+	}
+}
+
+func TestVerifyCountsViaClient(t *testing.T) {
+	stub := useStub(t)
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	report, err := cache.VerifyCounts(context.Background())
+	if err != nil || len(report) != 0 {
+		t.Fatalf("Got report %+v, error %v for an up to date cache", report, err)
+	}
+
+	stub.mu.Lock()
+	for i := 0; i < 9; i++ {
+		stub.entries["workspaces/1/tags"] = append(stub.entries["workspaces/1/tags"],
+			Basic{Id: fmt.Sprintf("2%d", i+1), Name: fmt.Sprintf("tag%d", i)})
+	}
+	stub.mu.Unlock()
+	report, err = cache.VerifyCounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Discrepancy{Workspace: "1", Kind: "tags", Cached: 1, Server: 10}
+	if len(report) != 1 || report[0] != want {
+		t.Errorf("Got report %+v, want %+v", report, want)
+	}
+}
+
+func TestMissingProjectRefreshedOnce(t *testing.T) {
+	stub := useStub(t)
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lists := func() int {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		return stub.gets["workspaces/1/projects"]
+	}
+	before := lists()
+	for i := 0; i < 3; i++ {
+		if id, err := cache.ProjectIdOrRefresh(context.Background(), "Gone"); err != nil || id != "" {
+			t.Fatalf("ProjectIdOrRefresh(Gone) = %q, %v", id, err)
+		}
+	}
+	if n := lists() - before; n != 1 {
+		t.Errorf("Projects listed %d times, want once", n)
+	}
+
+	// The next sync looks for it again.
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stub.mu.Lock()
+	stub.entries["workspaces/1/projects"] = append(stub.entries["workspaces/1/projects"],
+		Basic{Id: "11", Name: "Gone"})
+	stub.mu.Unlock()
+	if id, err := cache.ProjectIdOrRefresh(context.Background(), "Gone"); err != nil || id != "11" {
+		t.Errorf("After update, ProjectIdOrRefresh(Gone) = %q, %v", id, err)
+	}
+}
+
+func TestSectionsRoundTrip(t *testing.T) {
+	useStub(t)
+	cache.AddSection("10", Basic{Id: "40", Name: "In Progress:"})
+	cache.AddSectionObject("10", Basic{Id: "41", Name: "À faire"})
+	pairs := cache.SectionPairs("10")
+
+	secs := cache.ExportSections()
+	if got := secs["10"]; len(got) != 2 || got[0].Name != "In Progress:" || got[1].Name != "À faire" {
+		t.Fatalf("Exported %+v, want the names as in Asana", got)
+	}
+	cache.Reset()
+	cache.ImportSections(secs)
+	if got := cache.SectionPairs("10"); fmt.Sprint(got) != fmt.Sprint(pairs) {
+		t.Errorf("After import, got sections %+v, want %+v", got, pairs)
+	}
+	if id := cache.SectionId("10", "In Progress"); id != "40" {
+		t.Errorf("SectionId(In Progress) = %q after import", id)
+	}
+}
+
+func TestEnsureTagsInWorkspace(t *testing.T) {
+	stub := useStub(t)
+	setFlag(t, "domain", "Acme,Beta")
+	stub.entries["workspaces"] = []Basic{{Id: "1", Name: "Acme"}, {Id: "2", Name: "Beta"}}
+	stub.entries["workspaces/2/projects"] = []Basic{{Id: "12", Name: "Side"}}
+	stub.entries["workspaces/2/teams"] = nil
+	stub.entries["workspaces/2/tags"] = []Basic{{Id: "22", Name: "urgent"}}
+	stub.entries["workspaces/2/users"] = nil
+	if err := cache.update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := cache.EnsureTags(context.Background(), "2", []string{"urgent", "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "22" || ids[1] != "id-new" {
+		t.Errorf("Got tags %v, want the ones of workspace 2", ids)
+	}
+	if len(stub.values) != 1 || stub.values[0].Get("workspace") != "2" {
+		t.Errorf("Tag created via %v, want in workspace 2", stub.values)
+	}
+	if _, err := cache.EnsureTags(context.Background(), "3", []string{"urgent"}); err == nil {
+		t.Error("Got tags of a workspace which isn't cached")
+	}
+}
@@ -0,0 +1,76 @@
+package asana
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AsanaClient is how the cache talks to Asana. Replacing it allows exercising the cache
+// without network access.
+type AsanaClient interface {
+	// Get returns all the entries under path, retrieving the given opt_fields.
+	Get(ctx context.Context, path string, fields ...string) ([]Basic, error)
+	// Post runs a POST, PUT or DELETE against path, and returns the response body.
+	Post(ctx context.Context, method, path string, v url.Values) ([]byte, error)
+}
+
+// apiClient is the default AsanaClient, which talks to the Asana API over HTTP.
+type apiClient struct{}
+
+func (apiClient) Get(ctx context.Context, path string, fields ...string) ([]Basic, error) {
+	return getVarious(ctx, path, fields...)
+}
+
+func (apiClient) Post(ctx context.Context, method, path string, v url.Values) ([]byte, error) {
+	return runPost(ctx, method, path, v)
+}
+
+// SetClient makes the cache use ac to talk to Asana. Passing nil restores the default.
+func SetClient(ac AsanaClient) {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.client = ac
+}
+
+// defaultTimeout bounds every request, so a hung connection can't stall a sync.
+const defaultTimeout = time.Minute
+
+var (
+	httpMu     sync.RWMutex
+	httpClient = &http.Client{Timeout: defaultTimeout}
+)
+
+// SetHTTPClient makes all requests to Asana go via hc, e.g. to use a proxy or a
+// different timeout. Passing nil restores the default client.
+func SetHTTPClient(hc *http.Client) {
+	if hc == nil {
+		hc = &http.Client{Timeout: defaultTimeout}
+	}
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	httpClient = hc
+}
+
+func getHTTPClient() *http.Client {
+	httpMu.RLock()
+	defer httpMu.RUnlock()
+	return httpClient
+}
+
+// Ping checks that Asana can be reached and accepts the token, with a single request which
+// doesn't depend on the cache. An invalid or expired token results in ErrUnauthorized.
+func (c *acache) Ping() error {
+	var me BasicDataOne
+	err := runGetter(context.Background(), &me, "users/me", "gid")
+	return errors.Wrap(err, "Ping")
+}
+
+// Ping is like acache.Ping.
+func Ping() error {
+	return cache.Ping()
+}
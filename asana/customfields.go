@@ -0,0 +1,111 @@
+package asana
+
+import (
+	"context"
+	"flag"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var udas = flag.String("udas", "",
+	"Comma separated mapping from Asana custom field names to Taskwarrior UDAs,"+
+		" e.g. Stage=stage,Estimate=estimate. Only enum and text fields are synced.")
+
+type customField struct {
+	Basic
+	Type        string  `json:"type"`
+	TextValue   *string `json:"text_value"`
+	EnumValue   *Basic  `json:"enum_value"`
+	EnumOptions []Basic `json:"enum_options"`
+}
+
+type customFieldData struct {
+	Data     []customField `json:"data"`
+	NextPage *nextPage     `json:"next_page"`
+}
+
+// udaMap parses -udas into a map from Asana custom field name to Taskwarrior UDA.
+func udaMap() map[string]string {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(*udas, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return m
+}
+
+// CustomFieldUDAs returns the Taskwarrior UDAs which Asana custom fields are synced to.
+func CustomFieldUDAs() []string {
+	var names []string
+	for _, uda := range udaMap() {
+		names = append(names, uda)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getCustomFields retrieves the definitions of all the custom fields in the workspace.
+// Basic can't hold the enum options, so unlike other entities, these don't go through
+// AsanaClient.
+func getCustomFields(ctx context.Context, wid string) ([]customField, error) {
+	q := url.Values{}
+	q.Set("opt_fields", "name,type,enum_options.name")
+	q.Set("limit", strconv.Itoa(pageSize))
+
+	var result []customField
+	for {
+		var cd customFieldData
+		if err := runQuery(ctx, &cd, "workspaces/"+wid+"/custom_fields", q); err != nil {
+			return nil, err
+		}
+		result = append(result, cd.Data...)
+		if cd.NextPage == nil || cd.NextPage.Offset == "" {
+			return result, nil
+		}
+		q.Set("offset", cd.NextPage.Offset)
+	}
+}
+
+// toCustomFields returns the values of the synced custom fields on a task, keyed by
+// their Taskwarrior UDA.
+func toCustomFields(fields []customField) map[string]string {
+	m := udaMap()
+	if len(m) == 0 {
+		return nil
+	}
+	values := make(map[string]string)
+	for _, cf := range fields {
+		uda, has := m[cf.Name]
+		if !has {
+			continue
+		}
+		switch {
+		case cf.EnumValue != nil && cf.EnumValue.Name != "":
+			values[uda] = cf.EnumValue.Name
+		case cf.EnumValue != nil:
+			values[uda] = cache.CustomOption(cf.EnumValue.Id)
+		case cf.TextValue != nil:
+			values[uda] = *cf.TextValue
+		}
+	}
+	return values
+}
+
+// addCustomFields sets the values of the synced custom fields, which differ from prev.
+// Values which can't be mapped to the custom field in workspace wid are skipped.
+func addCustomFields(v url.Values, wid string, values, prev map[string]string) {
+	for name, uda := range udaMap() {
+		val := values[uda]
+		if val == prev[uda] {
+			continue
+		}
+		if fid, fval := cache.CustomFieldValue(wid, name, val); fid != "" {
+			v.Add("custom_fields["+fid+"]", fval)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package asana
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/manishrjain/asanawarrior/x"
+	"github.com/pkg/errors"
+)
+
+// dependencies returns the ids of the tasks the task depends on, as currently in Asana.
+func dependencies(ctx context.Context, taskid string) ([]string, error) {
+	var ot oneTask
+	if err := runGetter(ctx, &ot, "tasks/"+taskid, "dependencies"); err != nil {
+		return nil, errors.Wrapf(err, "dependencies of task: %v", taskid)
+	}
+	var ids []string
+	for _, d := range ot.Data.Dependencies {
+		ids = append(ids, d.Id)
+	}
+	return ids, nil
+}
+
+// checkCycle returns an error if making the task depend on deps would create a cycle,
+// by walking the dependencies of deps in Asana.
+func checkCycle(ctx context.Context, taskid string, deps []string) error {
+	seen := make(map[string]bool)
+	queue := append([]string(nil), deps...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == taskid {
+			return fmt.Errorf("Task %v can't depend on %v, as that would create a cycle",
+				taskid, strings.Join(deps, ","))
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		next, err := dependencies(ctx, id)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, next...)
+	}
+	return nil
+}
+
+// AddDependencies makes the Asana task depend on the given tasks, unless that would
+// create a cycle.
+func AddDependencies(ctx context.Context, taskid string, deps []string) error {
+	if len(deps) == 0 {
+		return nil
+	}
+	if err := checkCycle(ctx, taskid, deps); err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Add("dependencies", strings.Join(deps, ","))
+	_, err := runPost(ctx, "POST", fmt.Sprintf("tasks/%s/addDependencies", taskid), v)
+	return errors.Wrap(err, "AddDependencies")
+}
+
+func removeDependencies(ctx context.Context, taskid string, deps []string) error {
+	if len(deps) == 0 {
+		return nil
+	}
+	v := url.Values{}
+	v.Add("dependencies", strings.Join(deps, ","))
+	_, err := runPost(ctx, "POST", fmt.Sprintf("tasks/%s/removeDependencies", taskid), v)
+	return errors.Wrap(err, "removeDependencies")
+}
+
+// updateDependencies adds and removes dependencies of the Asana task, to match Taskwarrior.
+func updateDependencies(ctx context.Context, tw x.WarriorTask, asana x.WarriorTask) error {
+	if err := removeDependencies(ctx, tw.Xid, diff(asana.Depends, tw.Depends)); err != nil {
+		return err
+	}
+	return AddDependencies(ctx, tw.Xid, diff(tw.Depends, asana.Depends))
+}
@@ -0,0 +1,200 @@
+package asana
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// redirect is an http.RoundTripper sending all requests to host instead.
+type redirect struct {
+	host string
+	next http.RoundTripper
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = r.host
+	return r.next.RoundTrip(req)
+}
+
+// serve makes all requests to Asana go to h, for the duration of the test.
+func serve(t *testing.T, h http.Handler) {
+	srv := httptest.NewServer(h)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHTTPClient(&http.Client{Transport: redirect{host: u.Host, next: http.DefaultTransport}})
+	t.Cleanup(func() {
+		SetHTTPClient(nil)
+		srv.Close()
+		etags.Lock()
+		etags.m = nil
+		etags.Unlock()
+	})
+}
+
+// pager serves the entries in pages of the requested limit, like Asana does. Each page
+// has an ETag, and is only served if it doesn't match If-None-Match.
+type pager struct {
+	mu          sync.Mutex
+	entries     []Basic
+	pages       int // Number of pages served.
+	notModified int // Number of pages answered with 304 Not Modified.
+}
+
+func (p *pager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	limit, err := strconv.Atoi(req.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > pageSize {
+		http.Error(w, "bad limit", http.StatusBadRequest)
+		return
+	}
+	start := 0
+	if off := req.URL.Query().Get("offset"); off != "" {
+		start, _ = strconv.Atoi(strings.TrimPrefix(off, "page-"))
+	}
+	end := start + limit
+	if end > len(p.entries) {
+		end = len(p.entries)
+	}
+
+	page := BasicData{Data: p.entries[start:end]}
+	if end < len(p.entries) {
+		page.NextPage = &nextPage{Offset: fmt.Sprintf("page-%d", end)}
+	}
+	body, err := json.Marshal(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+	if req.Header.Get("If-None-Match") == etag {
+		p.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	p.pages++
+	w.Header().Set("ETag", etag)
+	w.Write(body)
+}
+
+func numbered(n int) []Basic {
+	entries := make([]Basic, n)
+	for i := range entries {
+		entries[i] = Basic{Id: strconv.Itoa(i), Name: fmt.Sprintf("Entry %d", i)}
+	}
+	return entries
+}
+
+func TestGetVariousCollectsAllPages(t *testing.T) {
+	for _, n := range []int{0, 1, pageSize, 2*pageSize + 50, 3001} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			p := &pager{entries: numbered(n)}
+			serve(t, p)
+
+			got, err := getVarious(context.Background(), "workspaces/1/projects", "name")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != n {
+				t.Fatalf("Got %d entries", len(got))
+			}
+			for i, b := range got {
+				if b != p.entries[i] {
+					t.Fatalf("Entry %d is %+v, want %+v", i, b, p.entries[i])
+				}
+			}
+			want := (n + pageSize - 1) / pageSize
+			if want == 0 {
+				want = 1
+			}
+			if p.pages != want {
+				t.Errorf("Fetched in %d pages, want %d", p.pages, want)
+			}
+		})
+	}
+}
+
+func TestGetVariousRevalidatesEveryPage(t *testing.T) {
+	p := &pager{entries: numbered(2*pageSize + 50)}
+	serve(t, p)
+	get := func() []Basic {
+		got, err := getVarious(context.Background(), "workspaces/1/projects", "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	get()
+	if got := get(); len(got) != len(p.entries) || p.notModified != 3 {
+		t.Fatalf("Unchanged listing: got %d entries, %d pages not modified", len(got), p.notModified)
+	}
+
+	// Only the last page changes.
+	p.mu.Lock()
+	p.entries[2*pageSize+10].Name = "Renamed"
+	p.entries = append(p.entries, Basic{Id: "new", Name: "New"})
+	p.mu.Unlock()
+	got := get()
+	if len(got) != len(p.entries) {
+		t.Fatalf("Got %d entries, want %d", len(got), len(p.entries))
+	}
+	if got[2*pageSize+10].Name != "Renamed" || got[len(got)-1].Id != "new" {
+		t.Errorf("Changes on the last page are missing: %+v", got[2*pageSize:])
+	}
+	if p.notModified != 5 {
+		t.Errorf("%d pages not modified, want 5", p.notModified)
+	}
+}
+
+func TestRecordCallStatuses(t *testing.T) {
+	cache.EnableAPIMetrics(true)
+	defer cache.EnableAPIMetrics(false)
+	for _, status := range []int{200, 201, 301, 304, 429, 429, 404, 500, 0} {
+		recordCall("GET", prefix+"/projects/10/tasks", time.Now(), status)
+	}
+	st := cache.APIMetrics()["GET projects/{gid}/tasks"]
+	if st.Count != 9 || st.Errors != 3 || st.RateLimited != 2 {
+		t.Errorf("Got %+v, want 9 calls, 3 errors and 2 rate limited", st)
+	}
+}
+
+func TestGetVariousTasksUnconditional(t *testing.T) {
+	p := &pager{entries: numbered(10)}
+	serve(t, p)
+	for i := 0; i < 2; i++ {
+		if _, err := getVarious(context.Background(), "projects/10/tasks", "name"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.pages != 2 || p.notModified != 0 {
+		t.Errorf("Got %d pages and %d not modified, want tasks listed in full every time",
+			p.pages, p.notModified)
+	}
+}
+
+func TestNotModifiedWithoutETag(t *testing.T) {
+	serve(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := getVarious(ctx, "workspaces/1/tags", "name")
+	if err == nil || ctx.Err() != nil {
+		t.Errorf("Got error %v, want the 304 to fail right away", err)
+	}
+}
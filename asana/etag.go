@@ -0,0 +1,38 @@
+package asana
+
+import "sync"
+
+// etagPage is a page of a listing, along with its ETag.
+type etagPage struct {
+	etag string
+	body []byte
+}
+
+// etags holds the last version of each page of the reference listings, keyed by the
+// listing, the fields retrieved and the number of the page, e.g. "workspaces/1/tags?name#0".
+// Offsets aren't part of the key, so there's at most one entry per page.
+var etags struct {
+	sync.Mutex
+	m map[string]etagPage
+}
+
+func cachedPage(key string) etagPage {
+	etags.Lock()
+	defer etags.Unlock()
+	return etags.m[key]
+}
+
+// storePage keeps the page, so it can be reused if it's unchanged next time. Responses
+// without an ETag aren't kept.
+func storePage(key, etag string, body []byte) {
+	etags.Lock()
+	defer etags.Unlock()
+	if etag == "" {
+		delete(etags.m, key)
+		return
+	}
+	if etags.m == nil {
+		etags.m = make(map[string]etagPage)
+	}
+	etags.m[key] = etagPage{etag: etag, body: body}
+}
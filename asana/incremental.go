@@ -0,0 +1,105 @@
+package asana
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manishrjain/asanawarrior/x"
+	"github.com/pkg/errors"
+)
+
+var incremental = flag.Bool("incremental", false,
+	"After the first sync, only fetch the Asana tasks modified since the last one."+
+		" Tasks deleted or moved in Asana are detected via the task ids of every project.")
+
+// lastSync holds the Asana tasks as of the last successful sync, so that incremental
+// syncs only need to fetch what changed since.
+var lastSync struct {
+	sync.Mutex
+	at    time.Time
+	tasks map[string]x.WarriorTask
+}
+
+// projectMembers returns the projects each task is part of, by the task ids. Empty tasks
+// and sections are skipped, like they are when syncing.
+func projectMembers(ctx context.Context) (map[string][]string, error) {
+	projects := syncedProjects()
+	var mu sync.Mutex
+	members := make(map[string][]string)
+	errc := make(chan error, len(projects))
+	for _, proj := range projects {
+		go func(proj Basic) {
+			list, err := getVarious(ctx, fmt.Sprintf("projects/%s/tasks", proj.Id), "name")
+			if err != nil {
+				errc <- errors.Wrapf(err, "projectMembers for project: %v", proj.Name)
+				return
+			}
+			mu.Lock()
+			for _, t := range list {
+				if len(t.Name) == 0 || (!*nativeSections && strings.HasSuffix(t.Name, ":")) {
+					continue
+				}
+				members[t.Id] = append(members[t.Id], proj.Name)
+			}
+			mu.Unlock()
+			errc <- nil
+		}(proj)
+	}
+
+	var rerr error
+	for range projects {
+		if err := <-errc; err != nil {
+			rerr = err
+		}
+	}
+	return members, rerr
+}
+
+// mergeDelta applies the tasks modified since the last sync to the known ones. Tasks
+// no longer in any synced project are dropped, and tasks which are there but got moved
+// or added without being modified are retrieved individually.
+func mergeDelta(ctx context.Context, known map[string]x.WarriorTask,
+	delta []x.WarriorTask) ([]x.WarriorTask, error) {
+	members, err := projectMembers(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "mergeDelta")
+	}
+
+	merged := make(map[string]x.WarriorTask, len(known))
+	for xid, wt := range known {
+		for _, p := range members[xid] {
+			if p == wt.Project {
+				merged[xid] = wt
+				break
+			}
+		}
+	}
+	for _, wt := range delta {
+		if prev, has := known[wt.Xid]; has && wt.Section == "" && prev.Project == wt.Project {
+			// Sections given by a preceding "name:" task can't be determined from
+			// just the delta.
+			wt.Section = prev.Section
+		}
+		merged[wt.Xid] = wt
+	}
+	for xid := range members {
+		if _, has := merged[xid]; has {
+			continue
+		}
+		wt, err := GetOneTask(ctx, xid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "mergeDelta task: %v", xid)
+		}
+		merged[xid] = wt
+	}
+
+	wtasks := make([]x.WarriorTask, 0, len(merged))
+	for _, wt := range merged {
+		wtasks = append(wtasks, wt)
+	}
+	return wtasks, nil
+}
@@ -0,0 +1,66 @@
+package asana
+
+import (
+	"log"
+	"sync"
+)
+
+// Logger receives the log messages of this package, so they can be routed into an
+// existing, leveled logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// stdLogger logs via the standard library. Debug messages are only shown with -verbose.
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
+
+func (stdLogger) Infof(format string, args ...interface{}) { log.Printf(format, args...) }
+
+func (stdLogger) Warnf(format string, args ...interface{}) { log.Printf("WARN "+format, args...) }
+
+func (stdLogger) Errorf(format string, args ...interface{}) { log.Printf("ERROR "+format, args...) }
+
+// logger is what the package logs via. It forwards to the Logger set via SetLogger, so
+// the Logger can be swapped while requests are being logged.
+var logger Logger = forwarder{}
+
+var (
+	loggerMu sync.RWMutex
+	current  Logger = stdLogger{}
+)
+
+// SetLogger makes the package log via l. A nil Logger restores the default.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	current = l
+}
+
+func getLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return current
+}
+
+// forwarder is a Logger passing every message on to the current Logger.
+type forwarder struct{}
+
+func (forwarder) Debugf(format string, args ...interface{}) { getLogger().Debugf(format, args...) }
+
+func (forwarder) Infof(format string, args ...interface{}) { getLogger().Infof(format, args...) }
+
+func (forwarder) Warnf(format string, args ...interface{}) { getLogger().Warnf(format, args...) }
+
+func (forwarder) Errorf(format string, args ...interface{}) { getLogger().Errorf(format, args...) }
@@ -0,0 +1,101 @@
+package asana
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EndpointStat holds the calls made to an Asana endpoint.
+type EndpointStat struct {
+	Count  int
+	Errors int // Calls which failed, or which Asana responded to with an error status.
+	// RateLimited calls are the ones Asana turned down with 429 Too Many Requests, to be
+	// retried. They aren't counted as errors.
+	RateLimited int
+	Total       time.Duration
+	Max         time.Duration
+}
+
+var (
+	metricsOn int32 // Accessed atomically, so recording costs nothing when disabled.
+	metricsMu sync.Mutex
+	metrics   map[string]EndpointStat
+)
+
+// EnableAPIMetrics starts recording the number and duration of API calls, by endpoint.
+// Disabling them drops what's been recorded so far.
+func (c *acache) EnableAPIMetrics(enable bool) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if enable {
+		if metrics == nil {
+			metrics = make(map[string]EndpointStat)
+		}
+		atomic.StoreInt32(&metricsOn, 1)
+	} else {
+		atomic.StoreInt32(&metricsOn, 0)
+		metrics = nil
+	}
+}
+
+// APIMetrics returns the calls made so far, keyed by method and endpoint, e.g.
+// "GET projects/{gid}/tasks". Returns nil unless EnableAPIMetrics was called.
+func (c *acache) APIMetrics() map[string]EndpointStat {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if metrics == nil {
+		return nil
+	}
+	out := make(map[string]EndpointStat, len(metrics))
+	for k, v := range metrics {
+		out[k] = v
+	}
+	return out
+}
+
+var gidExp = regexp.MustCompile(`/[0-9]+(/|$)`)
+
+// endpoint turns a request url into the endpoint it's recorded under, dropping the
+// query and replacing ids.
+func endpoint(method, url string) string {
+	path := strings.TrimPrefix(url, prefix+"/")
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	path = gidExp.ReplaceAllString("/"+path, "/{gid}$1")
+	return method + " " + strings.TrimPrefix(path, "/")
+}
+
+// recordCall records an API call which started at start, if metrics are enabled. status
+// is the HTTP status Asana responded with, or 0 if the call failed without a response.
+// Redirects and 304 Not Modified are successful calls.
+func recordCall(method, url string, start time.Time, status int) {
+	if atomic.LoadInt32(&metricsOn) == 0 {
+		return
+	}
+	dur := time.Since(start)
+	key := endpoint(method, url)
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if metrics == nil {
+		return
+	}
+	st := metrics[key]
+	st.Count++
+	switch {
+	case status == http.StatusTooManyRequests:
+		st.RateLimited++
+	case status == 0 || status >= 400:
+		st.Errors++
+	}
+	st.Total += dur
+	if dur > st.Max {
+		st.Max = dur
+	}
+	metrics[key] = st
+}
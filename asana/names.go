@@ -0,0 +1,38 @@
+package asana
+
+import "sync"
+
+// Directions in which a NameTransformer is applied.
+const (
+	ToTaskwarrior = "taskwarrior"
+	ToAsana       = "asana"
+)
+
+// NameTransformer rewrites task names as they're synced in the given direction, e.g.
+// to strip a prefix in Taskwarrior and add it back in Asana. Names should survive a
+// round trip, i.e. transforming the ToTaskwarrior result ToAsana should give back the
+// original name, or the transformation gets applied again on every sync.
+type NameTransformer func(direction, name string) string
+
+var (
+	namesMu         sync.RWMutex
+	nameTransformer NameTransformer
+)
+
+// SetNameTransformer makes task names get rewritten by t. Passing nil syncs names as
+// they are, which is the default.
+func SetNameTransformer(t NameTransformer) {
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	nameTransformer = t
+}
+
+func transformName(direction, name string) string {
+	namesMu.RLock()
+	t := nameTransformer
+	namesMu.RUnlock()
+	if t == nil {
+		return name
+	}
+	return t(direction, name)
+}
@@ -0,0 +1,59 @@
+package asana
+
+// change records entities newly added to the cache, until observers are notified.
+type change struct {
+	kind  string
+	added []Basic
+}
+
+// OnChange registers fn to be called with the entities newly added to the cache, by
+// update, CreateTag, EnsureTags and CreateProject. kind is one of "project", "tag" or
+// "user".
+//
+// fn is called without any cache lock held, so it can call back into the cache. It's
+// called from the goroutine which made the change, and calls for concurrent changes
+// can interleave.
+func (c *acache) OnChange(fn func(kind string, added []Basic)) {
+	c.Lock()
+	defer c.Unlock()
+	c.observers = append(c.observers, fn)
+}
+
+// recordChange queues added entities for notifyChanges. Must be called with the write
+// lock held.
+func (c *acache) recordChange(kind string, added []Basic) {
+	if len(added) == 0 || len(c.observers) == 0 {
+		return
+	}
+	c.pending = append(c.pending, change{kind: kind, added: added})
+}
+
+// notifyChanges passes the queued changes on to the observers. Must be called without
+// holding any lock.
+func (c *acache) notifyChanges() {
+	c.Lock()
+	pending, observers := c.pending, c.observers
+	c.pending = nil
+	c.Unlock()
+
+	for _, ch := range pending {
+		for _, fn := range observers {
+			fn(ch.kind, ch.added)
+		}
+	}
+}
+
+// newEntries returns the entries in cur, which aren't in prev.
+func newEntries(prev, cur []Basic) []Basic {
+	seen := make(map[string]bool, len(prev))
+	for _, b := range prev {
+		seen[b.Id] = true
+	}
+	var added []Basic
+	for _, b := range cur {
+		if !seen[b.Id] {
+			added = append(added, b)
+		}
+	}
+	return added
+}
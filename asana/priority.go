@@ -0,0 +1,74 @@
+package asana
+
+import (
+	"flag"
+	"net/url"
+	"strings"
+
+	"github.com/manishrjain/asanawarrior/x"
+)
+
+var priorityField = flag.String("priority_field", "Priority",
+	"Name of the Asana enum custom field which holds the task priority.")
+var priorities = flag.String("priorities", "H=High,M=Medium,L=Low",
+	"Comma separated mapping from Taskwarrior priorities to the options of -priority_field.")
+
+// priorityMap parses -priorities into a map from Taskwarrior priority to Asana option name.
+func priorityMap() map[string]string {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(*priorities, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return m
+}
+
+// toPriority returns the Taskwarrior priority held by the custom fields of a task in
+// workspace wid. If the task doesn't have the priority field, or its value isn't mapped,
+// x.UnmappedPriority is returned so neither side gets cleared.
+func toPriority(wid string, fields []customField) string {
+	for _, cf := range fields {
+		if cf.Name != *priorityField {
+			continue
+		}
+		cache.SetPriorityField(wid, cf)
+		if cf.EnumValue == nil || cf.EnumValue.Id == "" {
+			return ""
+		}
+		for tw, name := range priorityMap() {
+			if name == cf.EnumValue.Name {
+				return tw
+			}
+		}
+		break
+	}
+	return x.UnmappedPriority
+}
+
+// addPriority sets the Asana custom field value for the Taskwarrior priority, of a task
+// in workspace wid. It returns false if the priority can't be mapped to Asana, in which
+// case nothing is set.
+func addPriority(v url.Values, wid, pri string) bool {
+	if pri == x.UnmappedPriority {
+		return false
+	}
+	var name string
+	if pri != "" {
+		var has bool
+		if name, has = priorityMap()[pri]; !has {
+			return false
+		}
+	}
+	fid, oid := cache.PriorityOption(wid, name)
+	if fid == "" || (name != "" && oid == "") {
+		return false
+	}
+	if oid == "" {
+		oid = "null"
+	}
+	v.Add("custom_fields["+fid+"]", oid)
+	return true
+}
@@ -0,0 +1,128 @@
+package asana
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+
+	"github.com/manishrjain/asanawarrior/x"
+	"github.com/pkg/errors"
+)
+
+var missingProjects = flag.String("missing_projects", "skip",
+	"What to do with Taskwarrior tasks in a project which doesn't exist in Asana, e.g."+
+		" because it was deleted. One of: skip, create.")
+
+// resolveProject returns the workspace and id of the named project, for assigning a task
+// to it. If the project isn't in Asana, it's created if -missing_projects=create, and
+// otherwise an empty id is returned.
+func resolveProject(ctx context.Context, name string) (string, string, error) {
+	if name == "" {
+		return "", "", nil
+	}
+	wid, pid := cache.FindProject(name)
+	if pid != "" {
+		return wid, pid, nil
+	}
+	// It might have been created since the cache was last updated.
+	if _, err := cache.ProjectIdOrRefresh(ctx, name); err != nil {
+		return "", "", errors.Wrap(err, "resolveProject")
+	}
+	if wid, pid = cache.FindProject(name); pid != "" {
+		return wid, pid, nil
+	}
+	switch *missingProjects {
+	case "create":
+		logger.Infof("Creating missing project: %q", name)
+		pid, err := cache.CreateProject(ctx, name)
+		if err != nil {
+			return "", "", errors.Wrap(err, "resolveProject")
+		}
+		return cache.DefaultWorkspace(), pid, nil
+	case "skip":
+		return "", "", nil
+	}
+	return "", "", fmt.Errorf("Invalid value for -missing_projects: %q", *missingProjects)
+}
+
+// primaryMembership returns the first membership of the task in a synced project, which
+// isn't synced as a tag. That project is the one the task gets synced as part of, so
+// it's picked the same way no matter which project the task was retrieved via.
+func primaryMembership(tsk task) (psec, bool) {
+	for _, m := range tsk.Memberships {
+		if !isTagProject(m.Project.Name) && cache.ProjectName(m.Project.Id) != "" &&
+			(*syncArchived || !cache.IsArchived(m.Project.Id)) {
+			return m, true
+		}
+	}
+	return psec{}, false
+}
+
+// otherProjects returns the names of the projects the task is in besides proj, leaving
+// out the ones synced as tags.
+func otherProjects(tsk task, proj string) []string {
+	var names []string
+	for _, m := range tsk.Memberships {
+		if m.Project.Name != proj && !isTagProject(m.Project.Name) {
+			names = append(names, m.Project.Name)
+		}
+	}
+	return names
+}
+
+func addProject(ctx context.Context, tid, pid string) error {
+	v := url.Values{}
+	v.Add("project", pid)
+	_, err := runPost(ctx, "POST", fmt.Sprintf("tasks/%s/addProject", tid), v)
+	return err
+}
+
+// addProjects adds the task to the named projects. Unknown projects are skipped.
+func addProjects(ctx context.Context, tid string, names []string) error {
+	for _, name := range names {
+		_, pid := cache.FindProject(name)
+		if pid == "" {
+			var err error
+			if pid, err = cache.ProjectIdOrRefresh(ctx, name); err != nil {
+				return errors.Wrapf(err, "addProjects %q", name)
+			}
+		}
+		if pid == "" {
+			logger.Warnf("Skipping unknown project: %q", name)
+			continue
+		}
+		if err := addProject(ctx, tid, pid); err != nil {
+			return errors.Wrapf(err, "addProjects %q", name)
+		}
+	}
+	return nil
+}
+
+// updateProjects adds and removes the Asana task to and from projects other than the
+// primary one, to match Taskwarrior.
+func updateProjects(ctx context.Context, tw, asana x.WarriorTask) error {
+	add := without(diff(tw.Projects, asana.Projects), tw.Project)
+	if err := addProjects(ctx, tw.Xid, add); err != nil {
+		return errors.Wrap(err, "updateProjects")
+	}
+	for _, name := range without(diff(asana.Projects, tw.Projects), tw.Project) {
+		if _, pid := cache.FindProject(name); pid != "" {
+			if err := removeProject(ctx, tw.Xid, pid); err != nil {
+				return errors.Wrapf(err, "updateProjects %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// without returns the list, leaving out s.
+func without(list []string, s string) []string {
+	var result []string
+	for _, e := range list {
+		if e != s {
+			result = append(result, e)
+		}
+	}
+	return result
+}
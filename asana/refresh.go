@@ -0,0 +1,81 @@
+package asana
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// spaceIds returns the ids of the cached workspaces. Appropriate locks should be acquired
+// by the caller.
+func (c *acache) spaceIds() []string {
+	wids := make([]string, 0, len(c.spaces))
+	for wid := range c.spaces {
+		wids = append(wids, wid)
+	}
+	return wids
+}
+
+// RefreshProjects retrieves the projects of the synced workspaces again, including the
+// ones of their teams, without updating the rest of the cache. Sections are kept.
+func (c *acache) RefreshProjects() error {
+	defer c.notifyChanges()
+	c.RLock()
+	api, wids := c.api(), c.spaceIds()
+	c.RUnlock()
+
+	fresh := make(map[string]*wcache, len(wids))
+	for _, wid := range wids {
+		w := new(wcache)
+		if err := w.updateProjects(context.Background(), api, wid); err != nil {
+			return errors.Wrapf(err, "RefreshProjects workspace %q", wid)
+		}
+		fresh[wid] = w
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for wid, f := range fresh {
+		if w, err := c.writableSpace(wid); err == nil {
+			c.setProjects(w, f)
+		}
+	}
+	return nil
+}
+
+// setProjects replaces the projects and teams of w with the freshly retrieved ones. Must
+// be called with the write lock held.
+func (c *acache) setProjects(w, fresh *wcache) {
+	c.recordChange("project", newEntries(w.projects, fresh.projects))
+	w.projects, w.projectmap = fresh.projects, fresh.projectmap
+	w.teams, w.projectteam = fresh.teams, fresh.projectteam
+}
+
+// RefreshUsers retrieves the users of the synced workspaces again, without updating the
+// rest of the cache. This picks up users who joined since the last update.
+// Workspaces dropped from the cache in the meantime are skipped.
+func (c *acache) RefreshUsers() error {
+	defer c.notifyChanges()
+	c.RLock()
+	api, wids := c.api(), c.spaceIds()
+	c.RUnlock()
+
+	fresh := make(map[string]*wcache, len(wids))
+	for _, wid := range wids {
+		w := new(wcache)
+		if err := w.updateUsers(context.Background(), api, wid); err != nil {
+			return errors.Wrapf(err, "RefreshUsers workspace %q", wid)
+		}
+		fresh[wid] = w
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for wid, f := range fresh {
+		if w, err := c.writableSpace(wid); err == nil {
+			c.recordChange("user", newEntries(w.users, f.users))
+			w.users, w.usermap = f.users, f.usermap
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package asana_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manishrjain/asanawarrior/asana"
+	"github.com/manishrjain/asanawarrior/asanatest"
+	"github.com/manishrjain/asanawarrior/x"
+)
+
+// task1 is task 1 of project Work, in section Later.
+const task1 = `{"gid":"1","name":"Write","modified_at":"2020-01-02T10:00:00.000Z",
+	"created_at":"2020-01-01T10:00:00.000Z","memberships":[{"project":{"gid":"10","name":"Work"},
+	"section":{"gid":"40","name":"Later"}}]}`
+
+// tasksBody lists task1.
+const tasksBody = `{"data":[` + task1 + `]}`
+
+// setFlag sets the flag for the duration of the test.
+func setFlag(t *testing.T, name, value string) {
+	prev := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, prev) })
+}
+
+// workspace fills in the entries of workspace Acme, holding project Work with sections
+// Later and Now, tag urgent and user ann.
+func workspace(entries map[string][]asana.Basic) {
+	entries["workspaces"] = []asana.Basic{{Id: "1", Name: "Acme"}}
+	entries["workspaces/1/projects"] = []asana.Basic{{Id: "10", Name: "Work"}}
+	entries["workspaces/1/teams"] = nil
+	entries["workspaces/1/tags"] = []asana.Basic{{Id: "20", Name: "urgent"}}
+	entries["workspaces/1/users"] = []asana.Basic{{Id: "30", Name: "Ann", Email: "ann@example.com"}}
+	entries["projects/10/sections"] = []asana.Basic{{Id: "40", Name: "Later"}, {Id: "41", Name: "Now"}}
+}
+
+// newServer starts an asanatest.Server serving the workspace and its tasks, and makes
+// all requests go to it for the duration of the test.
+func newServer(t *testing.T) *asanatest.Server {
+	setFlag(t, "domain", "Acme")
+	srv := asanatest.NewServer()
+	workspace(srv.Entries)
+	srv.Bodies["GET projects/10/tasks"] = []byte(tasksBody)
+	asana.SetHTTPClient(srv.Client())
+	t.Cleanup(func() {
+		asana.SetHTTPClient(nil)
+		srv.Close()
+	})
+	return srv
+}
+
+// getTask syncs the tasks from Asana, and returns the only one.
+func getTask(t *testing.T) x.WarriorTask {
+	wtasks, err := asana.GetTasks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wtasks) != 1 {
+		t.Fatalf("Got %d tasks, want 1: %+v", len(wtasks), wtasks)
+	}
+	return wtasks[0]
+}
+
+func TestUpdateTaskMovesToSection(t *testing.T) {
+	srv := newServer(t)
+	at := getTask(t)
+	if at.Project != "Work" || at.Section != "Later" {
+		t.Fatalf("Got task in %q section %q", at.Project, at.Section)
+	}
+
+	tw := at
+	tw.Section = "Now"
+	if err := asana.UpdateTask(context.Background(), tw, at); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.MovedToSection("1", "10", "41") {
+		t.Errorf("Task not moved to section Now. Writes: %+v", srv.Writes())
+	}
+	if len(srv.Writes()) != 1 {
+		t.Errorf("Got writes besides moving the task: %+v", srv.Writes())
+	}
+}
+
+func TestUpdateTaskCreatesTag(t *testing.T) {
+	srv := newServer(t)
+	// The cache talks to a FakeClient instead, while tasks are still read and written via
+	// the server.
+	fake := asanatest.NewFakeClient()
+	workspace(fake.Entries)
+	fake.Responses["POST tags"] = []byte(`{"data":{"gid":"21","name":"new"}}`)
+	asana.SetClient(fake)
+	t.Cleanup(func() { asana.SetClient(nil) })
+
+	at := getTask(t)
+	tw := at
+	tw.Tags = []string{"new"}
+	if err := asana.UpdateTask(context.Background(), tw, at); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Calls) != 1 {
+		t.Fatalf("Got calls %+v, want one creating the tag", fake.Calls)
+	}
+	c := fake.Calls[0]
+	if c.Method != "POST" || c.Path != "tags" || c.Values.Get("name") != "new" ||
+		c.Values.Get("workspace") != "1" {
+		t.Errorf("Tag created via %+v", c)
+	}
+	writes := srv.Writes()
+	if len(writes) != 1 || writes[0].Path != "tasks/1/addTag" || writes[0].Values.Get("tag") != "21" {
+		t.Errorf("Tag not added to the task. Writes: %+v", writes)
+	}
+}
+
+func TestSectionOfBothIngestionPaths(t *testing.T) {
+	const task = `{"gid":"1","name":"Write","modified_at":"2020-01-02T10:00:00.000Z",
+		"created_at":"2020-01-01T10:00:00.000Z","memberships":[{"project":{"gid":"10","name":"Work"},
+		"section":{"gid":"40","name":"Later:"}}]}`
+	const colonTask = `{"gid":"40","name":"Later:","modified_at":"2020-01-02T10:00:00.000Z",
+		"created_at":"2020-01-01T10:00:00.000Z"}`
+	cases := []struct {
+		native string
+		tasks  string
+	}{
+		{"true", `{"data":[` + task + `]}`},
+		// Before native sections, the section is a colon suffixed task preceding its tasks.
+		{"false", `{"data":[` + colonTask + `,` + task + `]}`},
+	}
+	for _, tc := range cases {
+		t.Run("native_sections="+tc.native, func(t *testing.T) {
+			setFlag(t, "native_sections", tc.native)
+			srv := newServer(t)
+			srv.Entries["projects/10/sections"] = []asana.Basic{{Id: "40", Name: "Later:"}}
+			srv.Bodies["GET projects/10/tasks"] = []byte(tc.tasks)
+			srv.Bodies["GET tasks/1"] = []byte(`{"data":` + task + `}`)
+
+			if at := getTask(t); at.Section != "Later" {
+				t.Errorf("GetTasks: got section %q, want Later", at.Section)
+			}
+			one, err := asana.GetOneTask(context.Background(), "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if one.Section != "Later" {
+				t.Errorf("GetOneTask: got section %q, want Later", one.Section)
+			}
+		})
+	}
+}
+
+func TestCompleteReopenRoundTrip(t *testing.T) {
+	srv := newServer(t)
+	// list makes the server list task 1 with the given completion. Asana keeps
+	// completed_at around after a task is reopened.
+	list := func(completed bool) {
+		srv.Bodies["GET projects/10/tasks"] = []byte(fmt.Sprintf(`{"data":[{"gid":"1","name":"Write",
+			"completed":%v,"completed_at":"2020-01-03T10:00:00.000Z","modified_at":"2020-01-04T10:00:00.000Z",
+			"created_at":"2020-01-01T10:00:00.000Z","memberships":[{"project":{"gid":"10","name":"Work"},
+			"section":{"gid":"40","name":"Later"}}]}]}`, completed))
+	}
+	// update syncs tw to Asana, and checks the completed value sent.
+	update := func(tw, at x.WarriorTask, want string) {
+		t.Helper()
+		n := len(srv.Writes())
+		if err := asana.UpdateTask(context.Background(), tw, at); err != nil {
+			t.Fatal(err)
+		}
+		writes := srv.Writes()[n:]
+		if len(writes) != 1 || writes[0].Method != "PUT" || writes[0].Path != "tasks/1" ||
+			writes[0].Values.Get("completed") != want {
+			t.Fatalf("Want completed=%s sent, got writes: %+v", want, writes)
+		}
+	}
+
+	at := getTask(t)
+	done := at
+	done.Completed = at.Modified
+	update(done, at, "true")
+
+	list(true)
+	at = getTask(t)
+	if at.Completed.IsZero() {
+		t.Fatal("Task not completed in Asana")
+	}
+	open := at
+	open.Completed = time.Time{}
+	update(open, at, "false")
+
+	list(false)
+	at = getTask(t)
+	if !at.Completed.IsZero() {
+		t.Fatalf("Reopened task completed at %v", at.Completed)
+	}
+	update(done, at, "true")
+}
+
+// paths returns the method and path of each write.
+func paths(writes []asanatest.Call) []string {
+	var out []string
+	for _, c := range writes {
+		out = append(out, c.Method+" "+c.Path)
+	}
+	return out
+}
+
+func TestTaskInDeletedProject(t *testing.T) {
+	t.Run("skip", func(t *testing.T) {
+		setFlag(t, "missing_projects", "skip")
+		srv := newServer(t)
+		at := getTask(t)
+		tw := at
+		tw.Name = "Rewrite"
+		tw.Project = "Gone"
+		tw.Section = ""
+		if err := asana.UpdateTask(context.Background(), tw, at); err != nil {
+			t.Fatal(err)
+		}
+		// The other fields are still synced, but the task stays in its project.
+		writes := srv.Writes()
+		if got := paths(writes); len(got) != 1 || got[0] != "PUT tasks/1" {
+			t.Fatalf("Got writes %v, want only the update", got)
+		}
+		if got := writes[0].Values.Get("name"); got != "Rewrite" {
+			t.Errorf("Updated name to %q", got)
+		}
+
+		tw.Xid = ""
+		_, err := asana.AddNew(context.Background(), tw)
+		if err == nil || !strings.Contains(err.Error(), "Project not found") {
+			t.Errorf("AddNew to a missing project: got error %v", err)
+		}
+		if got := paths(srv.Writes()); len(got) != 1 {
+			t.Errorf("AddNew wrote %v", got[1:])
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		setFlag(t, "missing_projects", "create")
+		srv := newServer(t)
+		srv.Bodies["POST workspaces/1/projects"] = []byte(`{"data":{"gid":"11","name":"Gone"}}`)
+		at := getTask(t)
+		tw := at
+		tw.Project = "Gone"
+		tw.Section = ""
+		if err := asana.UpdateTask(context.Background(), tw, at); err != nil {
+			t.Fatal(err)
+		}
+		writes := srv.Writes()
+		want := []string{"POST workspaces/1/projects", "POST tasks/1/addProject", "POST tasks/1/removeProject"}
+		if got := paths(writes); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("Got writes %v, want %v", got, want)
+		}
+		if got := writes[0].Values.Get("name"); got != "Gone" {
+			t.Errorf("Created project %q", got)
+		}
+		if got := writes[1].Values.Get("project"); got != "11" {
+			t.Errorf("Added to project %q, want the created one", got)
+		}
+		if got := writes[2].Values.Get("project"); got != "10" {
+			t.Errorf("Removed from project %q, want Work", got)
+		}
+	})
+}
+
+func TestAddNewDryRun(t *testing.T) {
+	srv := newServer(t)
+	getTask(t)
+	setFlag(t, "dryrun", "true")
+
+	tw := x.WarriorTask{Uuid: "u1", Name: "Plan", Project: "Work", Section: "Now",
+		Tags: []string{"urgent", "new"}}
+	at, err := asana.AddNew(context.Background(), tw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.Xid != "dryrun-u1" || at.Name != "Plan" {
+		t.Errorf("Got task %+v, want a placeholder for it", at)
+	}
+	if writes := srv.Writes(); len(writes) != 0 {
+		t.Errorf("Writes sent under -dryrun: %+v", writes)
+	}
+}
+
+func TestAddNewUnconfirmedFindsCreatedTask(t *testing.T) {
+	srv := newServer(t)
+	getTask(t)
+	// Asana created the task, but the response got lost.
+	srv.Failures["POST tasks"] = 1
+	srv.Bodies["GET tasks/1"] = []byte(`{"data":` + task1 + `}`)
+
+	tw := x.WarriorTask{Uuid: "u1", Name: "Write", Project: "Work"}
+	at, err := asana.AddNew(context.Background(), tw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.Xid != "1" {
+		t.Errorf("Got task %q, want the one already created", at.Xid)
+	}
+	posts := 0
+	for _, p := range paths(srv.Writes()) {
+		if p == "POST tasks" {
+			posts++
+		}
+	}
+	if posts != 1 {
+		t.Errorf("Got writes %v, want the task created only once", paths(srv.Writes()))
+	}
+}
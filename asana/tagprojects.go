@@ -0,0 +1,89 @@
+package asana
+
+import (
+	"context"
+	"flag"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var tagProjects = flag.String("tag_projects", "",
+	"Comma separated Taskwarrior tags to sync as memberships of the Asana project with the"+
+		" same name, instead of as Asana tags. Entries ending in * match tags by prefix,"+
+		" e.g. client-*.")
+
+// isTagProject returns true if the Taskwarrior tag, or Asana project, of the given name
+// is synced as a project membership.
+func isTagProject(name string) bool {
+	if *tagProjects == "" {
+		return false
+	}
+	for _, rule := range strings.Split(*tagProjects, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if strings.HasSuffix(rule, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(rule, "*")) {
+				return true
+			}
+		} else if rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inOtherProject returns true if the task is part of any project not synced as a tag.
+func inOtherProject(tsk task) bool {
+	for _, m := range tsk.Memberships {
+		if !isTagProject(m.Project.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTags separates the tags synced as Asana tags from the ones synced as projects.
+func splitTags(all []string) (tags, projects []string) {
+	for _, t := range all {
+		if isTagProject(t) {
+			projects = append(projects, t)
+		} else {
+			tags = append(tags, t)
+		}
+	}
+	return tags, projects
+}
+
+// addTagProjects adds the task to the projects named, creating the missing ones.
+func addTagProjects(ctx context.Context, tid string, names []string) error {
+	for _, name := range names {
+		pid, err := cache.ProjectIdOrRefresh(ctx, name)
+		if err != nil {
+			return errors.Wrapf(err, "addTagProjects %q", name)
+		}
+		if pid == "" {
+			if pid, err = cache.CreateProject(ctx, name); err != nil {
+				return errors.Wrapf(err, "addTagProjects %q", name)
+			}
+		}
+		if err := addProject(ctx, tid, pid); err != nil {
+			return errors.Wrapf(err, "addTagProjects %q", name)
+		}
+	}
+	return nil
+}
+
+// removeTagProjects removes the task from the projects named.
+func removeTagProjects(ctx context.Context, tid string, names []string) error {
+	for _, name := range names {
+		if _, pid := cache.FindProject(name); pid != "" {
+			if err := removeProject(ctx, tid, pid); err != nil {
+				return errors.Wrapf(err, "removeTagProjects %q", name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package asana
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/manishrjain/asanawarrior/x"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidTask is returned for tasks which Asana would reject, instead of sending them.
+var ErrInvalidTask = errors.New("Invalid task")
+
+// clockSkew is how far in the future timestamps can be, before they're deemed invalid.
+const clockSkew = 5 * time.Minute
+
+// Validate checks that the task can be written to Asana: it must have a name and a
+// project, an assignee known to Asana if any, and no completion or creation time in the
+// future. All the problems found are reported together. Only the cache is consulted.
+// Projects which aren't in Asana are left to be handled as per -missing_projects.
+func Validate(t x.WarriorTask) error {
+	var problems []string
+	if strings.TrimSpace(t.Name) == "" {
+		problems = append(problems, "empty name")
+	}
+
+	if t.Project == "" {
+		problems = append(problems, "no project")
+	}
+	wid, _ := cache.FindProject(t.Project)
+	if wid == "" {
+		wid = cache.DefaultWorkspace()
+	}
+
+	// Users might not be listable, in which case assignees aren't synced at all.
+	if t.Assignee != "" && len(cache.Users()) > 0 && cache.WorkspaceUserId(wid, t.Assignee) == "" {
+		problems = append(problems, fmt.Sprintf("unknown assignee %q", t.Assignee))
+	}
+
+	future := time.Now().Add(clockSkew)
+	if t.Completed.After(future) {
+		problems = append(problems, fmt.Sprintf("completed in the future, at %v", t.Completed))
+	}
+	if t.Created.After(future) {
+		problems = append(problems, fmt.Sprintf("created in the future, at %v", t.Created))
+	}
+
+	if len(problems) > 0 {
+		return errors.Wrapf(ErrInvalidTask, "%q: %s", t.Name, strings.Join(problems, "; "))
+	}
+	return nil
+}
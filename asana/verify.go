@@ -0,0 +1,63 @@
+package asana
+
+import (
+	"context"
+	"flag"
+
+	"github.com/pkg/errors"
+)
+
+var verifyCache = flag.Bool("verify_cache", false,
+	"After updating the cache, list the projects, tags and users of each workspace again"+
+		" and warn if much fewer were cached, e.g. because of truncated fetches.")
+
+// Discrepancy is a kind of entity of a workspace, of which fewer were cached than Asana has.
+type Discrepancy struct {
+	Workspace string
+	Kind      string // One of "projects", "tags" or "users".
+	Cached    int
+	Server    int
+}
+
+// minCachedRatio is the fraction of the server count the cache is expected to hold.
+// Entities can get created between loading and verifying, so some slack is allowed.
+const minCachedRatio = 0.9
+
+// VerifyCounts counts the entities of each workspace in Asana, and reports the kinds of
+// which the cache holds much fewer. Asana doesn't return total counts, so the entities
+// are listed again via the client, only retrieving their ids. Entities lost between
+// listing and caching them, like pages of a truncated fetch, show up as a discrepancy.
+func (c *acache) VerifyCounts(ctx context.Context) ([]Discrepancy, error) {
+	c.RLock()
+	api := c.api()
+	cached := make(map[string]map[string]int)
+	for wid, w := range c.spaces {
+		cached[wid] = map[string]int{
+			"projects": len(w.projects),
+			"tags":     len(w.tags),
+			"users":    len(w.users),
+		}
+	}
+	c.RUnlock()
+
+	var report []Discrepancy
+	for wid, counts := range cached {
+		for kind, n := range counts {
+			list, err := api.Get(ctx, "workspaces/"+wid+"/"+kind, "gid")
+			total := len(list)
+			if kind == "users" && errors.Is(err, ErrForbidden) {
+				continue
+			}
+			if err != nil {
+				return report, errors.Wrapf(err, "VerifyCounts %s", kind)
+			}
+			if float64(n) < minCachedRatio*float64(total) {
+				logger.Warnf("Only %d of %d %s of workspace %v are cached.", n, total, kind, wid)
+				report = append(report, Discrepancy{
+					Workspace: wid, Kind: kind, Cached: n, Server: total,
+				})
+			}
+		}
+	}
+	return report, nil
+}
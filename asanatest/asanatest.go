@@ -0,0 +1,61 @@
+// Package asanatest provides helpers for testing code built on the asana package,
+// without talking to Asana.
+package asanatest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/manishrjain/asanawarrior/asana"
+)
+
+// Call is a write received by FakeClient.
+type Call struct {
+	Method string
+	Path   string
+	Values url.Values
+}
+
+// FakeClient is an asana.AsanaClient which serves canned entries, and records every
+// write it receives. Use it via asana.SetClient.
+type FakeClient struct {
+	sync.Mutex
+	// Entries are returned by Get, keyed by path. E.g. "workspaces".
+	Entries map[string][]asana.Basic
+	// Responses are returned by Post, keyed by method and path. E.g. "POST tags".
+	Responses map[string][]byte
+	// Calls holds all the writes received so far, in order.
+	Calls []Call
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		Entries:   make(map[string][]asana.Basic),
+		Responses: make(map[string][]byte),
+	}
+}
+
+func (f *FakeClient) Get(ctx context.Context, path string, fields ...string) ([]asana.Basic, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries, has := f.Entries[path]
+	if !has {
+		return nil, fmt.Errorf("FakeClient: no entries for %q", path)
+	}
+	return append([]asana.Basic(nil), entries...), nil
+}
+
+func (f *FakeClient) Post(ctx context.Context, method, path string, v url.Values) ([]byte, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.Calls = append(f.Calls, Call{Method: method, Path: path, Values: v})
+	return f.Responses[method+" "+path], nil
+}
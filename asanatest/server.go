@@ -0,0 +1,126 @@
+package asanatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/manishrjain/asanawarrior/asana"
+)
+
+// apiPath is the path under which Asana serves its API.
+const apiPath = "/api/1.0/"
+
+// Server is an in-memory Asana, serving canned entries over HTTP and recording every
+// write it receives. Unlike FakeClient, requests go through the whole asana package,
+// including pagination, retries and task conversion. Use it via asana.SetHTTPClient,
+// with the client returned by Client.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// Entries and Bodies should be filled in before making requests.
+	// Entries are served as the data of GET requests, keyed by path. E.g. "workspaces".
+	Entries map[string][]asana.Basic
+	// Bodies are served as is, keyed by method and path. E.g. "GET tasks/1". They take
+	// precedence over Entries.
+	Bodies map[string][]byte
+	// Failures holds the number of times to fail with 500 Internal Server Error, before
+	// serving as usual. Keyed like Bodies.
+	Failures map[string]int
+	// Calls holds all the writes received so far, in order.
+	Calls []Call
+}
+
+// NewServer starts a Server. Close it once done.
+func NewServer() *Server {
+	s := &Server{
+		Entries:  make(map[string][]asana.Basic),
+		Bodies:   make(map[string][]byte),
+		Failures: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Client returns an http.Client sending the requests meant for Asana to s instead.
+func (s *Server) Client() *http.Client {
+	target, err := url.Parse(s.URL)
+	if err != nil {
+		panic(err)
+	}
+	return &http.Client{Transport: redirect{target: target, next: s.Server.Client().Transport}}
+}
+
+// redirect is an http.RoundTripper sending all requests to target.
+type redirect struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	req.Host = r.target.Host
+	return r.next.RoundTrip(req)
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, apiPath)
+	key := req.Method + " " + path
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Method != "GET" {
+		body, _ := ioutil.ReadAll(req.Body)
+		values, _ := url.ParseQuery(string(body))
+		s.Calls = append(s.Calls, Call{Method: req.Method, Path: path, Values: values})
+	}
+	if s.Failures[key] > 0 {
+		s.Failures[key]--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if body, has := s.Bodies[key]; has {
+		w.Write(body)
+		return
+	}
+	if req.Method != "GET" {
+		w.Write([]byte(`{"data":{}}`))
+		return
+	}
+	entries, has := s.Entries[path]
+	if !has {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"errors":[{"message":"asanatest: no entries for %s"}]}`, path)
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Data []asana.Basic `json:"data"`
+	}{entries})
+}
+
+// Writes returns a copy of the writes received so far.
+func (s *Server) Writes() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.Calls...)
+}
+
+// MovedToSection returns true if the task was moved into the section of the project.
+func (s *Server) MovedToSection(taskId, projId, secId string) bool {
+	for _, c := range s.Writes() {
+		if c.Method == "POST" && c.Path == "tasks/"+taskId+"/addProject" &&
+			c.Values.Get("project") == projId && c.Values.Get("section") == secId {
+			return true
+		}
+	}
+	return false
+}
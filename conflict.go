@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/manishrjain/asanawarrior/x"
+)
+
+var conflicts = flag.String("conflicts", "newest",
+	"How to resolve fields changed both in Asana and Taskwarrior since the last sync."+
+		" One of: newest, asana, taskwarrior.")
+
+var directions = flag.String("directions", "",
+	"Comma separated fields to only sync one way, e.g. Project=asana,Tags=taskwarrior."+
+		" Changes made on the other side are overwritten. Fields not listed sync both ways.")
+
+// Direction is which way a field is synced.
+type Direction int
+
+const (
+	Bidirectional Direction = iota
+	AsanaWins
+	TaskwarriorWins
+)
+
+// fieldDirections parses the directions picked via -directions, by field name.
+func fieldDirections() (map[string]Direction, error) {
+	dirs := make(map[string]Direction)
+	for _, entry := range strings.Split(*directions, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || !isSyncedField(kv[0]) {
+			return nil, fmt.Errorf("Invalid entry in -directions: %q", entry)
+		}
+		switch kv[1] {
+		case "asana":
+			dirs[kv[0]] = AsanaWins
+		case "taskwarrior":
+			dirs[kv[0]] = TaskwarriorWins
+		case "both":
+			dirs[kv[0]] = Bidirectional
+		default:
+			return nil, fmt.Errorf("Invalid direction in -directions: %q", entry)
+		}
+	}
+	return dirs, nil
+}
+
+func isSyncedField(name string) bool {
+	for _, f := range x.SyncedFields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enforce copies the fields synced in direction dir, as per dirs, from src to dst, where
+// they differ. It returns whether any did.
+func enforce(dst *x.WarriorTask, src x.WarriorTask, dirs map[string]Direction, dir Direction) bool {
+	var changed bool
+	for _, f := range x.SyncedFields {
+		if dirs[f.Name] == dir && !f.Equal(*dst, src) {
+			f.Copy(dst, src)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ConflictResolver decides which side wins, for a field changed both in Asana and
+// Taskwarrior since the last sync.
+type ConflictResolver interface {
+	// AsanaWins returns true if the Asana value of the field should be kept.
+	AsanaWins(field string, asana, taskwr x.WarriorTask) bool
+}
+
+type asanaWins struct{}
+
+func (asanaWins) AsanaWins(field string, asana, taskwr x.WarriorTask) bool { return true }
+
+type taskwarriorWins struct{}
+
+func (taskwarriorWins) AsanaWins(field string, asana, taskwr x.WarriorTask) bool { return false }
+
+// newestWins keeps the value from whichever side was modified last.
+type newestWins struct{}
+
+func (newestWins) AsanaWins(field string, asana, taskwr x.WarriorTask) bool {
+	return !taskwr.Modified.After(asana.Modified)
+}
+
+// directed resolves conflicts on fields synced one way in that direction, and leaves the
+// rest to next.
+type directed struct {
+	dirs map[string]Direction
+	next ConflictResolver
+}
+
+func (d directed) AsanaWins(field string, asana, taskwr x.WarriorTask) bool {
+	switch d.dirs[field] {
+	case AsanaWins:
+		return true
+	case TaskwarriorWins:
+		return false
+	}
+	return d.next.AsanaWins(field, asana, taskwr)
+}
+
+// resolver returns the ConflictResolver picked via -conflicts, overridden by dirs, the
+// parsed -directions.
+func resolver(dirs map[string]Direction) (ConflictResolver, error) {
+	var r ConflictResolver
+	switch *conflicts {
+	case "asana":
+		r = asanaWins{}
+	case "taskwarrior":
+		r = taskwarriorWins{}
+	case "newest":
+		r = newestWins{}
+	default:
+		return nil, fmt.Errorf("Invalid value for -conflicts: %q", *conflicts)
+	}
+	if len(dirs) > 0 {
+		r = directed{dirs: dirs, next: r}
+	}
+	return r, nil
+}
+
+// changedFields returns the synced fields which differ between the tasks.
+func changedFields(a, b x.WarriorTask) []x.Field {
+	var changed []x.Field
+	for _, f := range x.SyncedFields {
+		if !f.Equal(a, b) {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
+
+// onlyChanged returns asana, with the fields of tw changed since prev, the task as of the
+// last sync, copied over. Updating Asana with it only sends those fields.
+func onlyChanged(tw, prev, asana x.WarriorTask) x.WarriorTask {
+	for _, f := range changedFields(prev, tw) {
+		f.Copy(&asana, tw)
+	}
+	return asana
+}
+
+// mergeConflict merges the Asana and Taskwarrior versions of a task changed on both
+// sides. If base, the task as of the last sync, is known, fields changed on only one
+// side since are taken from that side, and r only picks the winner of fields changed on
+// both. Otherwise, r picks the winner of every differing field. It returns the merged
+// task, and whether Asana and Taskwarrior respectively need to be updated with it. Every
+// resolved conflict is logged, so it's possible to audit what got overwritten.
+func mergeConflict(asana, taskwr x.WarriorTask, base *x.WarriorTask,
+	r ConflictResolver) (x.WarriorTask, bool, bool) {
+	merged := taskwr
+	// Comments, the permalink, the creator and hearts only flow from Asana.
+	merged.Annotations = asana.Annotations
+	merged.URL = asana.URL
+	merged.CreatedBy = asana.CreatedBy
+	merged.Hearts = asana.Hearts
+
+	var toAsana, toTaskwr bool
+	for _, f := range x.SyncedFields {
+		switch {
+		case f.Equal(asana, taskwr):
+		case base != nil && f.Equal(*base, asana):
+			// Only changed in Taskwarrior.
+			toAsana = true
+		case base != nil && f.Equal(*base, taskwr):
+			// Only changed in Asana.
+			f.Copy(&merged, asana)
+			toTaskwr = true
+		case r.AsanaWins(f.Name, asana, taskwr):
+			fmt.Printf("Conflict on %s of [%q]: keeping Asana value\n", f.Name, taskwr.Name)
+			f.Copy(&merged, asana)
+			toTaskwr = true
+		default:
+			fmt.Printf("Conflict on %s of [%q]: keeping Taskwarrior value\n", f.Name, taskwr.Name)
+			toAsana = true
+		}
+	}
+	return merged, toAsana, toTaskwr
+}
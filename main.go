@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/0xAX/notificator"
@@ -20,6 +25,8 @@ var dbpath = flag.String("db", os.Getenv("HOME")+"/.task/asanawarrior.db",
 	"File path for db which stores certain sync information.")
 var notifyInterval = flag.Int("interval", 10,
 	"Minimum duration in seconds between successive notifications. Set to zero for no notifications.")
+var cachepath = flag.String("cache", os.Getenv("HOME")+"/.task/asanawarrior.cache",
+	"File path for the cache of Asana workspaces, projects, tags and users.")
 var maxDeletes = flag.Int("deletes", 5,
 	"If Asanawarrior sees more than these number of deletes, it's going to crash to"+
 		" protect your Asana from mass deletion.")
@@ -90,9 +97,21 @@ func taskwKey(uuid string) []byte {
 	return []byte(fmt.Sprintf("taskw-%s", uuid))
 }
 
+// stateKey stores the task as of the last sync, to tell which fields changed since.
+func stateKey(xid string) []byte {
+	return []byte(fmt.Sprintf("state-%s", xid))
+}
+
 func storeInDb(asanaTask, twTask x.WarriorTask) {
+	state, err := json.Marshal(twTask)
+	if err != nil {
+		log.Fatalf("Unable to marshal task state: %v", err)
+	}
 	if err := db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketName)
+		if err := b.Put(stateKey(asanaTask.Xid), state); err != nil {
+			return err
+		}
 		if err := b.Put(asanaKey(asanaTask.Xid),
 			[]byte(asanaTask.Modified.Format(time.RFC3339))); err != nil {
 			return err
@@ -126,7 +145,21 @@ func getSyncTimestamps(xid string, uuid string) (time.Time, time.Time) {
 	return at, tt
 }
 
-func syncMatch(m *Match, deleteFromAsana *[]*Match) error {
+// lastState returns the task as of the last sync, if it's known.
+func lastState(xid string) (x.WarriorTask, bool) {
+	var wt x.WarriorTask
+	found := false
+	db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketName).Get(stateKey(xid))
+		found = len(data) > 0 && json.Unmarshal(data, &wt) == nil
+		return nil
+	})
+	return wt, found
+}
+
+// syncMatch syncs the task, with the fields in dirs only synced one way.
+func syncMatch(ctx context.Context, dirs map[string]Direction, m *Match,
+	deleteFromAsana *[]*Match) error {
 	if m.Xid == "" {
 		// Task not present in Asana, but present in TW.
 
@@ -139,7 +172,19 @@ func syncMatch(m *Match, deleteFromAsana *[]*Match) error {
 			// This task used to have an Asana ID. But, we can't find the corresponding Asana task.
 			// It can happen when Asana task was deleted.
 			// If so, delete the task from TW as well.
+			exists, err := asana.Exists(ctx, m.TaskWr.Xid)
+			if err != nil {
+				return errors.Wrap(err, "Delete from Taskwarrior")
+			}
+			if exists {
+				// Still in Asana, just not in any of the projects being synced.
+				fmt.Printf("Not in synced projects, keeping: [%q]\n", m.TaskWr.Name)
+				return nil
+			}
 			fmt.Printf("Delete from Taskwarrior: [%q]\n", m.TaskWr.Name)
+			if skipTaskwarrior("delete", m.TaskWr.Uuid, m.TaskWr.Xid) {
+				return nil
+			}
 			pushNotification("Delete", m.TaskWr.Name)
 
 			if err := taskwarrior.Delete(m.TaskWr); err != nil {
@@ -150,10 +195,13 @@ func syncMatch(m *Match, deleteFromAsana *[]*Match) error {
 
 		// Create in Asana.
 		fmt.Printf("Create in Asana: [%q]\n", m.TaskWr.Name)
-		asanaUpdated, err := asana.AddNew(m.TaskWr)
+		asanaUpdated, err := asana.AddNew(ctx, m.TaskWr)
 		if err != nil {
 			return errors.Wrap(err, "create asana addnew")
 		}
+		if skipTaskwarrior("modify", m.TaskWr.Uuid, asanaUpdated.Xid) {
+			return nil
+		}
 
 		// Update TW with the Xid.
 		if err := taskwarrior.OverwriteUuid(asanaUpdated, m.TaskWr.Uuid); err != nil {
@@ -173,6 +221,9 @@ func syncMatch(m *Match, deleteFromAsana *[]*Match) error {
 		// No Asana xid found in Taskwarrior. So, create it.
 
 		fmt.Printf("Create in Taskwarrior: [%q]\n", m.Asana.Name)
+		if skipTaskwarrior("add", "", m.Asana.Xid) {
+			return nil
+		}
 		pushNotification("Create", m.Asana.Name)
 		uuid, err := taskwarrior.AddNew(m.Asana)
 		if err != nil {
@@ -198,20 +249,43 @@ func syncMatch(m *Match, deleteFromAsana *[]*Match) error {
 	// Task is present in both Asana and TW.
 	asanaTs, taskwTs := getSyncTimestamps(m.Asana.Xid, m.TaskWr.Uuid)
 
+	if approxAfter(m.Asana.Modified, asanaTs) && !m.TaskWr.Deleted &&
+		approxAfter(m.TaskWr.Modified, taskwTs) {
+		// Both were updated.
+		return syncConflict(ctx, dirs, m)
+	}
+
 	if approxAfter(m.Asana.Modified, asanaTs) {
 		// Asana was updated. Overwrite TW.
 		fmt.Printf("Overwrite Taskwarrior: [%q] [time diff: %v]\n",
 			m.Asana.Name, m.Asana.Modified.Sub(asanaTs))
-		pushNotification("Update", m.Asana.Name)
+		if !asana.DryRun() {
+			pushNotification("Update", m.Asana.Name)
+		}
 
-		if err := taskwarrior.OverwriteUuid(m.Asana, m.TaskWr.Uuid); err != nil {
+		incoming := m.Asana
+		latest := m.Asana
+		if enforce(&incoming, m.TaskWr, dirs, TaskwarriorWins) {
+			// Revert the changes made in Asana to fields only synced from Taskwarrior.
+			if err := asana.UpdateTask(ctx, incoming, m.Asana); err != nil {
+				return errors.Wrap(err, "Overwrite Taskwarrior directions")
+			}
+			var err error
+			if latest, err = asana.GetOneTask(ctx, m.Xid); err != nil {
+				return errors.Wrap(err, "Overwrite Taskwarrior GetOneTask")
+			}
+		}
+		if skipTaskwarrior("modify", m.TaskWr.Uuid, m.Xid) {
+			return nil
+		}
+		if err := taskwarrior.OverwriteUuid(incoming, m.TaskWr.Uuid); err != nil {
 			return errors.Wrap(err, "Overwrite Taskwarrior")
 		}
 		updated, err := taskwarrior.GetTask(m.TaskWr.Uuid)
 		if err != nil {
 			return errors.Wrap(err, "Overwrite Taskwarrior GetTask")
 		}
-		storeInDb(m.Asana, updated)
+		storeInDb(latest, updated)
 		return nil
 	}
 
@@ -223,10 +297,15 @@ func syncMatch(m *Match, deleteFromAsana *[]*Match) error {
 		}
 
 		fmt.Printf("Deleting task from Asana: [%q]\n", m.TaskWr.Name)
-		pushNotification("Deleting from Asana", m.TaskWr.Name)
-		if err := asana.Delete(m.Xid); err != nil {
+		if !asana.DryRun() {
+			pushNotification("Deleting from Asana", m.TaskWr.Name)
+		}
+		if err := asana.Delete(ctx, m.Xid); err != nil {
 			return errors.Wrap(err, "Delete task from Asana")
 		}
+		if asana.DryRun() {
+			return nil
+		}
 
 		// Don't delete from boltdb, but update the timestamps,
 		// so we don't reapply this deletion.
@@ -238,27 +317,194 @@ func syncMatch(m *Match, deleteFromAsana *[]*Match) error {
 		return nil
 	}
 
+	if m.TaskWr.Parent != "" && m.TaskWr.Parent != x.PendingParent &&
+		m.TaskWr.Parent != m.Asana.Parent && !approxAfter(m.TaskWr.Modified, taskwTs) {
+		// The parent of this task has only now been synced to Asana. Link them up.
+		fmt.Printf("Set parent in Asana: [%q]\n", m.TaskWr.Name)
+		if err := asana.SetParent(ctx, m.Xid, m.TaskWr.Parent); err != nil {
+			return errors.Wrap(err, "syncMatch SetParent")
+		}
+		return nil
+	}
+
+	if !approxAfter(m.TaskWr.Modified, taskwTs) {
+		// Dependencies on tasks which have only now been synced can be linked up.
+		if deps := missing(m.TaskWr.Depends, m.Asana.Depends, nil); len(deps) > 0 {
+			fmt.Printf("Add dependencies in Asana: [%q]\n", m.TaskWr.Name)
+			if err := asana.AddDependencies(ctx, m.Xid, deps); err != nil {
+				return errors.Wrap(err, "syncMatch AddDependencies")
+			}
+			return nil
+		}
+		if deps := missing(m.Asana.Depends, m.TaskWr.Depends, taskwarrior.HasTask); len(deps) > 0 {
+			fmt.Printf("Add dependencies in Taskwarrior: [%q]\n", m.TaskWr.Name)
+			if skipTaskwarrior("modify", m.TaskWr.Uuid, m.Xid) {
+				return nil
+			}
+			if err := taskwarrior.OverwriteUuid(m.Asana, m.TaskWr.Uuid); err != nil {
+				return errors.Wrap(err, "syncMatch dependencies")
+			}
+			updated, err := taskwarrior.GetTask(m.TaskWr.Uuid)
+			if err != nil {
+				return errors.Wrap(err, "syncMatch dependencies GetTask")
+			}
+			storeInDb(m.Asana, updated)
+			return nil
+		}
+		if len(m.TaskWr.Projects) == 0 && len(m.Asana.Projects) > 0 {
+			// Synced before other projects were, so they'd be dropped on the next update
+			// from Taskwarrior. Record them first.
+			fmt.Printf("Add projects in Taskwarrior: [%q]\n", m.TaskWr.Name)
+			if skipTaskwarrior("modify", m.TaskWr.Uuid, m.Xid) {
+				return nil
+			}
+			if err := taskwarrior.OverwriteUuid(m.Asana, m.TaskWr.Uuid); err != nil {
+				return errors.Wrap(err, "syncMatch projects")
+			}
+			updated, err := taskwarrior.GetTask(m.TaskWr.Uuid)
+			if err != nil {
+				return errors.Wrap(err, "syncMatch projects GetTask")
+			}
+			storeInDb(m.Asana, updated)
+			return nil
+		}
+	}
+
 	if approxAfter(m.TaskWr.Modified, taskwTs) {
+		prev, found := lastState(m.Xid)
+		if found && len(changedFields(prev, m.TaskWr)) == 0 {
+			// Only fields which aren't synced changed. Nothing to push to Asana.
+			if !asana.DryRun() {
+				storeInDb(m.Asana, m.TaskWr)
+			}
+			return nil
+		}
+
 		// TW was updated. Overwrite Asana.
 		fmt.Printf("Overwrite Asana: [%q] [time diff: %v]\n",
 			m.TaskWr.Name, m.TaskWr.Modified.Sub(taskwTs))
 
-		if err := asana.UpdateTask(m.TaskWr, m.Asana); err != nil {
+		outgoing := m.TaskWr
+		revert := enforce(&outgoing, m.Asana, dirs, AsanaWins)
+		if found {
+			// Don't send fields which weren't changed in Taskwarrior since the last sync.
+			outgoing = onlyChanged(outgoing, prev, m.Asana)
+		}
+		// Under -dryrun, this only logs the writes it would make.
+		if err := asana.UpdateTask(ctx, outgoing, m.Asana); err != nil {
 			return errors.Wrap(err, "syncMatch overwrite asana")
 		}
-		updated, err := asana.GetOneTask(m.Xid)
+		if asana.DryRun() {
+			if revert {
+				skipTaskwarrior("modify", m.TaskWr.Uuid, m.Xid)
+			}
+			return nil
+		}
+		updated, err := asana.GetOneTask(ctx, m.Xid)
 		if err != nil {
 			return errors.Wrap(err, "syncMatch GetOneTask")
 		}
-		storeInDb(updated, m.TaskWr)
+		taskwUpdated := m.TaskWr
+		if revert {
+			// Revert the changes made in Taskwarrior to fields only synced from Asana.
+			if err := taskwarrior.OverwriteUuid(updated, m.TaskWr.Uuid); err != nil {
+				return errors.Wrap(err, "syncMatch directions")
+			}
+			if taskwUpdated, err = taskwarrior.GetTask(m.TaskWr.Uuid); err != nil {
+				return errors.Wrap(err, "syncMatch directions GetTask")
+			}
+		}
+		storeInDb(updated, taskwUpdated)
 		return nil
 	}
 	return nil
 }
 
-func runSync() {
-	atasks, err := asana.GetTasks()
-	// atasks, err := asana.GetTasks(1)
+// syncConflict syncs a task which was updated both in Asana and Taskwarrior since the
+// last sync, by merging both versions field by field, against the last synced state.
+func syncConflict(ctx context.Context, dirs map[string]Direction, m *Match) error {
+	r, err := resolver(dirs)
+	if err != nil {
+		return errors.Wrap(err, "syncConflict")
+	}
+	var base *x.WarriorTask
+	if prev, found := lastState(m.Xid); found {
+		base = &prev
+	}
+	merged, toAsana, toTaskwr := mergeConflict(m.Asana, m.TaskWr, base, r)
+	fmt.Printf("Merge conflicting changes: [%q]\n", m.TaskWr.Name)
+	if !asana.DryRun() {
+		pushNotification("Merge", m.TaskWr.Name)
+	}
+
+	if toAsana {
+		if err := asana.UpdateTask(ctx, merged, m.Asana); err != nil {
+			return errors.Wrap(err, "syncConflict overwrite asana")
+		}
+	}
+	if asana.DryRun() {
+		if toTaskwr {
+			skipTaskwarrior("modify", m.TaskWr.Uuid, m.Xid)
+		}
+		return nil
+	}
+	if toTaskwr {
+		if err := taskwarrior.OverwriteUuid(merged, m.TaskWr.Uuid); err != nil {
+			return errors.Wrap(err, "syncConflict overwrite taskwarrior")
+		}
+	}
+
+	asanaUpdated, err := asana.GetOneTask(ctx, m.Xid)
+	if err != nil {
+		return errors.Wrap(err, "syncConflict GetOneTask")
+	}
+	taskwUpdated, err := taskwarrior.GetTask(m.TaskWr.Uuid)
+	if err != nil {
+		return errors.Wrap(err, "syncConflict GetTask")
+	}
+	storeInDb(asanaUpdated, taskwUpdated)
+	return nil
+}
+
+// skipTaskwarrior logs the Taskwarrior write and returns true, if it should be skipped
+// due to -dryrun. It's logged like the skipped Asana writes, so runs can be diffed.
+func skipTaskwarrior(op, uuid, xid string) bool {
+	if !asana.DryRun() {
+		return false
+	}
+	v := url.Values{}
+	v.Add("uuid", uuid)
+	v.Add("xid", xid)
+	fmt.Printf("DRYRUN\ttaskwarrior\t%s\t%s\n", op, v.Encode())
+	return true
+}
+
+// missing returns the entries of want which aren't in have, and pass the filter if any.
+func missing(want, have []string, filter func(string) bool) []string {
+	in := make(map[string]bool, len(have))
+	for _, h := range have {
+		in[h] = true
+	}
+	var result []string
+	for _, w := range want {
+		if !in[w] && (filter == nil || filter(w)) {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+// runSync syncs all the tasks, with the fields in dirs only synced one way. Cancelling
+// ctx stops it from picking up any more tasks, and aborts the requests in flight.
+func runSync(ctx context.Context, dirs map[string]Direction) {
+	atasks, err := asana.GetTasks(ctx)
+	if ctx.Err() != nil {
+		fmt.Println("Sync cancelled.")
+		return
+	}
+	if errors.Is(err, asana.ErrNoWorkspaces) {
+		log.Fatalf("%v. Please check that -token is valid and hasn't expired.", err)
+	}
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
@@ -280,10 +526,11 @@ func runSync() {
 
 	matches := generateMatches(atasks, twtasks)
 	deletes := make([]*Match, 0, 10)
-	for _, m := range matches {
-		if err := syncMatch(m, &deletes); err != nil {
-			log.Printf("syncMatch error: %v %+v", err, m)
-		}
+	failures := syncAll(ctx, dirs, matches, &deletes)
+	if ctx.Err() != nil {
+		printFailures(failures)
+		fmt.Println("Sync cancelled.")
+		return
 	}
 
 	if len(deletes) > *maxDeletes {
@@ -299,12 +546,17 @@ Crashing to avoid mass deletes from Asana!
 `, len(deletes), *maxDeletes)
 		os.Exit(1)
 	}
-	for _, m := range deletes {
-		if err := syncMatch(m, nil); err != nil {
-			log.Printf("syncMatch error: %v %+v", err, m)
-		}
-	}
+	failures = append(failures, syncAll(ctx, dirs, deletes, nil)...)
+	printFailures(failures)
 
+	if asana.DryRun() {
+		// The cache might hold placeholders for projects and tags which weren't created.
+		fmt.Println("Dry run DONE.")
+		return
+	}
+	if err := asana.SaveCache(*cachepath); err != nil {
+		log.Printf("Unable to save cache: %v", err)
+	}
 	fmt.Println("All synced up. DONE.")
 }
 
@@ -352,13 +604,21 @@ func processNotifications() {
 
 func main() {
 	flag.Parse()
+	// Parse these once upfront, so bad values fail right away instead of mid sync.
+	dirs, err := fieldDirections()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := resolver(dirs); err != nil {
+		log.Fatal(err)
+	}
+	taskwarrior.SyncUDAs(asana.CustomFieldUDAs()...)
 	fmt.Println("Asanawarrior v1.0 - Bringing the power of Taskwarrior to Asana")
 	notify = notificator.New(notificator.Options{
 		AppName: "Asanawarrior",
 	})
 	go processNotifications()
 
-	var err error
 	db, err = bolt.Open(*dbpath, 0600, nil)
 	if err != nil {
 		log.Fatalf("Unable to open bolt db at %v. Error: %v", *dbpath, err)
@@ -372,16 +632,31 @@ func main() {
 		return nil
 	})
 
+	if err := asana.LoadCache(*cachepath); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		log.Printf("Unable to load cache at %v. Error: %v", *cachepath, err)
+	}
+
+	// Interrupting stops the sync in progress, and shuts down cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Initiate a sync right away.
 	fmt.Println()
 	fmt.Println("Starting sync at", time.Now())
-	runSync()
+	runSync(ctx, dirs)
 
 	// And then do it at regular intervals.
 	ticker := time.NewTicker(time.Duration(*duration) * time.Minute)
-	for t := range ticker.C {
-		fmt.Println()
-		fmt.Println("Starting sync at", t)
-		runSync()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Shutting down.")
+			return
+		case t := <-ticker.C:
+			fmt.Println()
+			fmt.Println("Starting sync at", t)
+			runSync(ctx, dirs)
+		}
 	}
 }
@@ -0,0 +1,121 @@
+package taskwarrior
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/manishrjain/asanawarrior/x"
+)
+
+// uuidList holds task UUIDs, like depends. Taskwarrior 2.5 exports these as a comma
+// separated string, later versions as an array. Both import the former.
+type uuidList []string
+
+func (l *uuidList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*l = splitList(s)
+	return nil
+}
+
+func (l uuidList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.Join(l, ","))
+}
+
+// known maps the Xids of the tasks synced to Taskwarrior to their UUIDs. It's rebuilt
+// by GetTasks, and kept up to date as tasks are synced.
+var known struct {
+	sync.RWMutex
+	uuids map[string]string
+}
+
+func indexTasks(wtasks []x.WarriorTask) {
+	known.Lock()
+	defer known.Unlock()
+	known.uuids = make(map[string]string, len(wtasks))
+	for _, wt := range wtasks {
+		if wt.Xid != "" && !wt.Deleted {
+			known.uuids[wt.Xid] = wt.Uuid
+		}
+	}
+}
+
+func remember(xid, uuid string) {
+	if xid == "" || uuid == "" {
+		return
+	}
+	known.Lock()
+	defer known.Unlock()
+	if known.uuids == nil {
+		known.uuids = make(map[string]string)
+	}
+	known.uuids[xid] = uuid
+}
+
+// HasTask returns true if the Asana task has been synced to Taskwarrior.
+func HasTask(xid string) bool {
+	known.RLock()
+	defer known.RUnlock()
+	_, has := known.uuids[xid]
+	return has
+}
+
+// isSynced returns true if the Taskwarrior task has been synced to Asana.
+func isSynced(uuid string) bool {
+	known.RLock()
+	defer known.RUnlock()
+	for _, u := range known.uuids {
+		if u == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// toUuids returns the UUIDs of the tasks with the given Xids. Tasks which haven't been
+// synced to Taskwarrior yet are skipped, so those dependencies get linked up later.
+func toUuids(xids []string) uuidList {
+	known.RLock()
+	defer known.RUnlock()
+	var uuids uuidList
+	for _, xid := range xids {
+		if uuid, has := known.uuids[xid]; has {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+// knownXids returns the Xids of the tasks synced to Taskwarrior, by their UUIDs.
+func knownXids() map[string]string {
+	known.RLock()
+	defer known.RUnlock()
+	xids := make(map[string]string, len(known.uuids))
+	for xid, uuid := range known.uuids {
+		xids[uuid] = xid
+	}
+	return xids
+}
+
+// resolveDepends turns the UUIDs of dependencies into Xids, as found in xids.
+// Dependencies which haven't been synced to Asana yet are dropped, until they are.
+func resolveDepends(wtasks []x.WarriorTask, xids map[string]string) {
+	for i := range wtasks {
+		wt := &wtasks[i]
+		var deps []string
+		for _, uuid := range wt.Depends {
+			if xid := xids[uuid]; xid != "" {
+				deps = append(deps, xid)
+			}
+		}
+		wt.Depends = deps
+	}
+}
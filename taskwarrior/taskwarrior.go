@@ -7,6 +7,7 @@ import (
 	"log"
 	"os/exec"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/manishrjain/asanawarrior/x"
@@ -17,16 +18,97 @@ const (
 	stamp = "20060102T150405Z"
 )
 
+type annotation struct {
+	Entry       string `json:"entry,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
 type task struct {
-	Completed   string   `json:"end,omitempty"`
-	Created     string   `json:"entry,omitempty"`
-	Description string   `json:"description,omitempty"`
-	Modified    string   `json:"modified,omitempty"`
-	Project     string   `json:"project,omitempty"`
-	Status      string   `json:"status,omitempty"`
-	Tags        []string `json:"tags,omitempty"`
-	Uuid        string   `json:"uuid,omitempty"`
-	Xid         string   `json:"xid,omitempty"`
+	Annotations []annotation `json:"annotations,omitempty"`
+	AssigneeSt  string       `json:"xassigneestatus,omitempty"`
+	Completed   string       `json:"end,omitempty"`
+	Created     string       `json:"entry,omitempty"`
+	CreatedBy   string       `json:"xcreatedby,omitempty"`
+	Depends     uuidList     `json:"depends,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Due         string       `json:"due,omitempty"`
+	Followers   string       `json:"xfollowers,omitempty"`
+	Hearts      int          `json:"xhearts,omitempty"`
+	Modified    string       `json:"modified,omitempty"`
+	Notes       string       `json:"xnotes,omitempty"`
+	Parent      string       `json:"xparent,omitempty"`
+	Priority    string       `json:"priority,omitempty"`
+	Project     string       `json:"project,omitempty"`
+	Projects    string       `json:"xprojects,omitempty"`
+	Recur       string       `json:"recur,omitempty"`
+	RecurParent string       `json:"parent,omitempty"` // Template of a recurring instance.
+	Imask       json.Number  `json:"imask,omitempty"`
+	Scheduled   string       `json:"scheduled,omitempty"`
+	Status      string       `json:"status,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	URL         string       `json:"xurl,omitempty"`
+	Uuid        string       `json:"uuid,omitempty"`
+	Xid         string       `json:"xid,omitempty"`
+
+	// UDAs holds the values of the UDAs registered via SyncUDAs.
+	UDAs map[string]string `json:"-"`
+}
+
+// udas are the additional UDAs being synced, e.g. for Asana custom fields.
+var udas []string
+
+// SyncUDAs registers additional UDAs to be synced, as WarriorTask.CustomFields.
+func SyncUDAs(names ...string) {
+	udas = append(udas, names...)
+}
+
+type plainTask task
+
+func (t *task) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*plainTask)(t)); err != nil {
+		return err
+	}
+	if len(udas) == 0 {
+		return nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	t.UDAs = nil
+	for _, name := range udas {
+		val, has := raw[name]
+		if !has {
+			continue
+		}
+		if t.UDAs == nil {
+			t.UDAs = make(map[string]string)
+		}
+		var s string
+		if err := json.Unmarshal(val, &s); err != nil {
+			// Not a string, e.g. a numeric UDA.
+			s = string(val)
+		}
+		t.UDAs[name] = s
+	}
+	return nil
+}
+
+func (t task) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(plainTask(t))
+	if err != nil || len(t.UDAs) == 0 {
+		return data, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for name, val := range t.UDAs {
+		if val != "" {
+			raw[name] = val
+		}
+	}
+	return json.Marshal(raw)
 }
 
 var uuidExp *regexp.Regexp
@@ -57,6 +139,21 @@ func (t task) ToWarriorTask() (x.WarriorTask, error) {
 		}
 	}
 
+	var due time.Time
+	if len(t.Due) > 0 {
+		due, err = time.Parse(stamp, t.Due)
+		if err != nil {
+			return empty, err
+		}
+	}
+	var start time.Time
+	if len(t.Scheduled) > 0 {
+		start, err = time.Parse(stamp, t.Scheduled)
+		if err != nil {
+			return empty, err
+		}
+	}
+
 	var ass, sec string
 	var tags []string
 	for _, tg := range t.Tags {
@@ -73,19 +170,40 @@ func (t task) ToWarriorTask() (x.WarriorTask, error) {
 		}
 	}
 
+	var notes []string
+	for _, a := range t.Annotations {
+		notes = append(notes, a.Description)
+	}
+
 	wt := x.WarriorTask{
-		Assignee: ass,
-		Created:  cts,
-		Modified: mts,
-		Name:     t.Description,
-		Project:  t.Project,
-		Section:  sec,
-		Tags:     tags,
-		Xid:      t.Xid,
-		Uuid:     t.Uuid,
-		Deleted:  t.Status == "deleted",
-	}
-	if !dts.IsZero() {
+		Annotations:  notes,
+		Assignee:     ass,
+		Created:      cts,
+		CustomFields: t.UDAs,
+		Depends:      t.Depends,
+		Due:          due,
+		Followers:    splitList(t.Followers),
+		Projects:     splitList(t.Projects),
+		Hearts:       t.Hearts,
+		Modified:     mts,
+		Name:         t.Description,
+		Notes:        t.Notes,
+		Parent:       t.Parent,
+		Priority:     t.Priority,
+		Project:      t.Project,
+		Recur:        t.Recur,
+		Section:      sec,
+		Start:        start,
+		Tags:         tags,
+		URL:          t.URL,
+		CreatedBy:    t.CreatedBy,
+		Xid:          t.Xid,
+		Uuid:         t.Uuid,
+		Deleted:      t.Status == "deleted",
+	}
+	wt.AssigneeStatus = t.AssigneeSt
+	if !dts.IsZero() && t.Status != "pending" {
+		// Reopened tasks can keep their end date.
 		wt.Completed = dts
 	}
 	return wt, nil
@@ -121,15 +239,64 @@ func GetTasks() ([]x.WarriorTask, error) {
 
 	wtasks := make([]x.WarriorTask, 0, 100)
 	for _, t := range tasks {
+		if t.Status == "recurring" {
+			// Templates of recurring tasks aren't synced, only their instances are.
+			continue
+		}
 		if wt, err := t.ToWarriorTask(); err == nil {
 			wtasks = append(wtasks, wt)
 		} else {
 			log.Printf("Error while converting task to WarriorTask: %+v", err)
 		}
 	}
+	xids := xidsOf(wtasks)
+	resolveParents(wtasks, xids)
+	resolveDepends(wtasks, xids)
+	indexTasks(wtasks)
 	return wtasks, nil
 }
 
+// xidsOf returns the Xids of the tasks, by their UUIDs.
+func xidsOf(wtasks []x.WarriorTask) map[string]string {
+	xids := make(map[string]string, len(wtasks))
+	for _, wt := range wtasks {
+		xids[wt.Uuid] = wt.Xid
+	}
+	return xids
+}
+
+// resolveParents allows the parent of a task to be set to the UUID of another
+// Taskwarrior task, and replaces it with the Xid of that task, as found in xids. If that
+// task hasn't been synced to Asana yet, the parent is set to x.PendingParent, so the link
+// gets deferred until it is.
+func resolveParents(wtasks []x.WarriorTask, xids map[string]string) {
+	for i := range wtasks {
+		wt := &wtasks[i]
+		if uuidExp.FindString(wt.Parent) == wt.Parent && wt.Parent != "" {
+			if wt.Parent = xids[wt.Parent]; wt.Parent == "" {
+				wt.Parent = x.PendingParent
+			}
+		}
+	}
+}
+
+// isPending returns true if parent is the UUID of a Taskwarrior task, which hasn't been
+// synced to Asana yet.
+func isPending(parent string) bool {
+	return parent != "" && uuidExp.FindString(parent) == parent && !isSynced(parent)
+}
+
+// splitList splits a comma separated UDA value.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
 func generateTags(wt x.WarriorTask) []string {
 	tags := make([]string, len(wt.Tags), len(wt.Tags)+2)
 	copy(tags, wt.Tags)
@@ -151,16 +318,44 @@ func createNew(wt x.WarriorTask) task {
 	tags := generateTags(wt)
 
 	t := task{
+		AssigneeSt:  wt.AssigneeStatus,
 		Created:     wt.Created.Format(stamp),
+		UDAs:        wt.CustomFields,
+		Depends:     toUuids(wt.Depends),
 		Description: wt.Name,
+		Followers:   strings.Join(wt.Followers, ","),
+		Projects:    strings.Join(wt.Projects, ","),
+		Hearts:      wt.Hearts,
+		Notes:       wt.Notes,
+		Parent:      wt.Parent,
+		Priority:    wt.Priority,
 		Project:     wt.Project,
+		Recur:       wt.Recur,
 		Status:      status,
 		Tags:        tags,
+		URL:         wt.URL,
+		CreatedBy:   wt.CreatedBy,
 		Xid:         wt.Xid,
 	}
 	if !wt.Completed.IsZero() {
 		t.Completed = wt.Completed.Format(stamp)
 	}
+	if !wt.Due.IsZero() {
+		t.Due = wt.Due.UTC().Format(stamp)
+	}
+	if !wt.Start.IsZero() {
+		t.Scheduled = wt.Start.UTC().Format(stamp)
+	}
+	for _, a := range wt.Annotations {
+		// The time of the comment is part of the annotation, so just use a stable entry.
+		t.Annotations = append(t.Annotations, annotation{Entry: t.Created, Description: a})
+	}
+	if t.Priority == x.UnmappedPriority {
+		t.Priority = ""
+	}
+	if t.Parent == x.PendingParent {
+		t.Parent = ""
+	}
 	return t
 }
 
@@ -182,14 +377,38 @@ func doImport(t task) (string, error) {
 
 func AddNew(wt x.WarriorTask) (string, error) {
 	t := createNew(wt)
-	return doImport(t)
+	uuid, err := doImport(t)
+	if err == nil {
+		remember(wt.Xid, uuid)
+	}
+	return uuid, err
 }
 
 func OverwriteUuid(asana x.WarriorTask, uuid string) error {
 	t := createNew(asana)
 	t.Uuid = uuid
-	_, err := doImport(t)
-	return err
+	prevs, err := getTasks(uuid)
+	if err != nil {
+		return errors.Wrap(err, "OverwriteUuid")
+	}
+	if len(prevs) == 1 {
+		prev := prevs[0]
+		if asana.Priority == x.UnmappedPriority {
+			// Asana can't tell us the priority, so keep whatever Taskwarrior has.
+			t.Priority = prev.Priority
+		}
+		if asana.Parent == x.PendingParent || (asana.Parent == "" && isPending(prev.Parent)) {
+			// Keep the UUID of the parent, until it gets synced.
+			t.Parent = prev.Parent
+		}
+		// Asana doesn't know about recurrence, so keep the task an instance of its template.
+		t.Recur, t.RecurParent, t.Imask = prev.Recur, prev.RecurParent, prev.Imask
+	}
+	if _, err := doImport(t); err != nil {
+		return err
+	}
+	remember(asana.Xid, uuid)
+	return nil
 }
 
 func Delete(prev x.WarriorTask) error {
@@ -209,5 +428,14 @@ func GetTask(uuid string) (x.WarriorTask, error) {
 	if len(tasks) > 1 {
 		log.Fatalf("Multiple tasks matching a UUID: %+v", tasks)
 	}
-	return tasks[0].ToWarriorTask()
+	wt, err := tasks[0].ToWarriorTask()
+	if err != nil {
+		return wt, err
+	}
+	// Resolve the parent and dependencies like GetTasks does, via the synced tasks.
+	wtasks := []x.WarriorTask{wt}
+	xids := knownXids()
+	resolveParents(wtasks, xids)
+	resolveDepends(wtasks, xids)
+	return wtasks[0], nil
 }
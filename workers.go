@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+)
+
+var workers = flag.Int("workers", 1,
+	"Number of tasks to sync in parallel. Requests rate limited by Asana are retried as usual.")
+
+// syncFailure is a task which couldn't be synced.
+type syncFailure struct {
+	name string
+	err  error
+}
+
+// syncAll syncs the matches using -workers goroutines, with the fields in dirs only synced
+// one way. Failures don't stop the other matches from being synced, and are returned once
+// all of them are done. Deletions from Asana are collected in deletes, if it isn't nil.
+func syncAll(ctx context.Context, dirs map[string]Direction, matches []*Match,
+	deletes *[]*Match) []syncFailure {
+	n := *workers
+	if n < 1 {
+		n = 1
+	}
+
+	var mu sync.Mutex
+	var failures []syncFailure
+	var deferred []*Match
+	work := make(chan *Match)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range work {
+				var del []*Match
+				var err error
+				if deletes != nil {
+					err = syncMatch(ctx, dirs, m, &del)
+				} else {
+					err = syncMatch(ctx, dirs, m, nil)
+				}
+
+				mu.Lock()
+				deferred = append(deferred, del...)
+				if err != nil {
+					log.Printf("syncMatch error: %v %+v", err, m)
+					failures = append(failures, syncFailure{name: matchName(m), err: err})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, m := range matches {
+		if ctx.Err() != nil {
+			// Cancelled. The rest get synced next time.
+			break
+		}
+		work <- m
+	}
+	close(work)
+	wg.Wait()
+
+	if deletes != nil {
+		*deletes = append(*deletes, deferred...)
+	}
+	return failures
+}
+
+// matchName returns the name of the task, from whichever side has it.
+func matchName(m *Match) string {
+	if m.TaskWr.Name != "" {
+		return m.TaskWr.Name
+	}
+	return m.Asana.Name
+}
+
+// printFailures summarizes the tasks which couldn't be synced.
+func printFailures(failures []syncFailure) {
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Printf("%27s: %d\n", "Tasks failed to sync", len(failures))
+	for _, f := range failures {
+		fmt.Printf("  [%q]: %v\n", f.name, f.err)
+	}
+}
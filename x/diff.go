@@ -0,0 +1,128 @@
+package x
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Field is a WarriorTask field, which can be changed on either side.
+type Field struct {
+	Name  string
+	Equal func(a, b WarriorTask) bool
+	Copy  func(dst *WarriorTask, src WarriorTask)
+}
+
+// SameSet returns true if a and b hold the same strings, regardless of order.
+func SameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+// sameList returns true if a and b hold the same strings in the same order. Nil and
+// empty slices are the same.
+func sameList(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameMap returns true if a and b hold the same keys and values. Nil and empty maps are
+// the same.
+func sameMap(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, has := b[k]; !has || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncedFields are the fields synced in both directions between Asana and Taskwarrior.
+var SyncedFields = []Field{
+	{"Name", func(a, b WarriorTask) bool { return a.Name == b.Name },
+		func(d *WarriorTask, s WarriorTask) { d.Name = s.Name }},
+	{"Notes", func(a, b WarriorTask) bool { return a.Notes == b.Notes },
+		func(d *WarriorTask, s WarriorTask) { d.Notes = s.Notes }},
+	{"Assignee", func(a, b WarriorTask) bool { return a.Assignee == b.Assignee },
+		func(d *WarriorTask, s WarriorTask) { d.Assignee = s.Assignee }},
+	{"AssigneeStatus", func(a, b WarriorTask) bool { return a.AssigneeStatus == b.AssigneeStatus },
+		func(d *WarriorTask, s WarriorTask) { d.AssigneeStatus = s.AssigneeStatus }},
+	{"Completed", func(a, b WarriorTask) bool { return a.Completed.IsZero() == b.Completed.IsZero() },
+		func(d *WarriorTask, s WarriorTask) { d.Completed = s.Completed }},
+	{"Due", func(a, b WarriorTask) bool { return a.Due.Equal(b.Due) },
+		func(d *WarriorTask, s WarriorTask) { d.Due = s.Due }},
+	{"Start", func(a, b WarriorTask) bool { return a.Start.Equal(b.Start) },
+		func(d *WarriorTask, s WarriorTask) { d.Start = s.Start }},
+	{"Priority", func(a, b WarriorTask) bool { return a.Priority == b.Priority },
+		func(d *WarriorTask, s WarriorTask) { d.Priority = s.Priority }},
+	{"Project", func(a, b WarriorTask) bool { return a.Project == b.Project },
+		func(d *WarriorTask, s WarriorTask) { d.Project = s.Project }},
+	{"Projects", func(a, b WarriorTask) bool { return SameSet(a.Projects, b.Projects) },
+		func(d *WarriorTask, s WarriorTask) { d.Projects = s.Projects }},
+	{"Section", func(a, b WarriorTask) bool { return a.Section == b.Section },
+		func(d *WarriorTask, s WarriorTask) { d.Section = s.Section }},
+	{"Tags", func(a, b WarriorTask) bool { return SameSet(a.Tags, b.Tags) },
+		func(d *WarriorTask, s WarriorTask) { d.Tags = s.Tags }},
+	{"Followers", func(a, b WarriorTask) bool { return SameSet(a.Followers, b.Followers) },
+		func(d *WarriorTask, s WarriorTask) { d.Followers = s.Followers }},
+	{"Depends", func(a, b WarriorTask) bool { return SameSet(a.Depends, b.Depends) },
+		func(d *WarriorTask, s WarriorTask) { d.Depends = s.Depends }},
+	{"Parent", func(a, b WarriorTask) bool { return a.Parent == b.Parent },
+		func(d *WarriorTask, s WarriorTask) { d.Parent = s.Parent }},
+	{"CustomFields", func(a, b WarriorTask) bool { return sameMap(a.CustomFields, b.CustomFields) },
+		func(d *WarriorTask, s WarriorTask) { d.CustomFields = s.CustomFields }},
+}
+
+// Diff returns the names of the fields which differ between t and other. Modified,
+// Created and Uuid are ignored, since they differ between Asana and Taskwarrior for
+// the same task.
+func (t WarriorTask) Diff(other WarriorTask) []string {
+	var diff []string
+	for _, f := range SyncedFields {
+		if !f.Equal(t, other) {
+			diff = append(diff, f.Name)
+		}
+	}
+	if !sameList(t.Annotations, other.Annotations) {
+		diff = append(diff, "Annotations")
+	}
+	if t.URL != other.URL {
+		diff = append(diff, "URL")
+	}
+	if t.CreatedBy != other.CreatedBy {
+		diff = append(diff, "CreatedBy")
+	}
+	if t.Hearts != other.Hearts {
+		diff = append(diff, "Hearts")
+	}
+	if t.Xid != other.Xid {
+		diff = append(diff, "Xid")
+	}
+	if t.Recur != other.Recur {
+		diff = append(diff, "Recur")
+	}
+	if t.Deleted != other.Deleted {
+		diff = append(diff, "Deleted")
+	}
+	return diff
+}
+
+// Equal returns true if Diff finds no differing fields.
+func (t WarriorTask) Equal(other WarriorTask) bool {
+	return len(t.Diff(other)) == 0
+}
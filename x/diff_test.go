@@ -0,0 +1,66 @@
+package x
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	due := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	base := WarriorTask{
+		Name:         "Write",
+		Due:          due,
+		Tags:         []string{"a", "b"},
+		CustomFields: map[string]string{"size": "L"},
+		Annotations:  []string{"first", "second"},
+	}
+	cases := []struct {
+		name string
+		edit func(t *WarriorTask)
+		want string
+	}{
+		{"same", func(t *WarriorTask) {}, ""},
+		{"ignored fields", func(t *WarriorTask) {
+			t.Uuid, t.Modified, t.Created = "u1", due, due
+		}, ""},
+		{"same due elsewhere", func(t *WarriorTask) { t.Due = due.In(time.FixedZone("X", 3600)) }, ""},
+		{"tags reordered", func(t *WarriorTask) { t.Tags = []string{"b", "a"} }, ""},
+		{"tag added", func(t *WarriorTask) { t.Tags = []string{"a", "b", "c"} }, "Tags"},
+		{"custom field changed", func(t *WarriorTask) { t.CustomFields = map[string]string{"size": "S"} },
+			"CustomFields"},
+		{"custom field renamed", func(t *WarriorTask) { t.CustomFields = map[string]string{"area": "L"} },
+			"CustomFields"},
+		{"no custom fields", func(t *WarriorTask) { t.CustomFields = nil }, "CustomFields"},
+		{"annotations reordered", func(t *WarriorTask) { t.Annotations = []string{"second", "first"} },
+			"Annotations"},
+		{"several", func(t *WarriorTask) { t.Name, t.Due, t.Xid = "Read", time.Time{}, "1" },
+			"Name,Due,Xid"},
+	}
+	for _, tc := range cases {
+		other := base
+		tc.edit(&other)
+		if got := strings.Join(base.Diff(other), ","); got != tc.want {
+			t.Errorf("%s: Diff = %q, want %q", tc.name, got, tc.want)
+		}
+		if got := base.Equal(other); got != (tc.want == "") {
+			t.Errorf("%s: Equal = %v", tc.name, got)
+		}
+	}
+}
+
+func TestEqualNilAndEmpty(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b WarriorTask
+	}{
+		{"custom fields", WarriorTask{}, WarriorTask{CustomFields: map[string]string{}}},
+		{"annotations", WarriorTask{}, WarriorTask{Annotations: []string{}}},
+		{"tags", WarriorTask{}, WarriorTask{Tags: []string{}}},
+	}
+	for _, tc := range cases {
+		if !tc.a.Equal(tc.b) || !tc.b.Equal(tc.a) {
+			t.Errorf("%s: nil and empty differ: %v", tc.name, tc.a.Diff(tc.b))
+		}
+	}
+}
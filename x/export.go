@@ -0,0 +1,109 @@
+package x
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// exportTask is the JSON representation of WarriorTask written by ExportTasks.
+type exportTask struct {
+	Annotations  []string          `json:"annotations"`
+	Assignee     string            `json:"assignee"`
+	Completed    string            `json:"completed"`
+	Created      string            `json:"created"`
+	CreatedBy    string            `json:"created_by"`
+	CustomFields map[string]string `json:"custom_fields"`
+	Depends      []string          `json:"depends"`
+	Due          string            `json:"due"`
+	Followers    []string          `json:"followers"`
+	Hearts       int               `json:"hearts"`
+	Modified     string            `json:"modified"`
+	Name         string            `json:"name"`
+	Notes        string            `json:"notes"`
+	Parent       string            `json:"parent"`
+	Priority     string            `json:"priority"`
+	Project      string            `json:"project"`
+	Projects     []string          `json:"projects"`
+	Recur        string            `json:"recur"`
+	Section      string            `json:"section"`
+	Start        string            `json:"start"`
+	Tags         []string          `json:"tags"`
+	URL          string            `json:"url"`
+	Xid          string            `json:"xid"`
+	Uuid         string            `json:"uuid"`
+	Deleted      bool              `json:"deleted"`
+
+	AssigneeStatus string `json:"assignee_status"`
+}
+
+// formatTime formats t as RFC3339, leaving zero times empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ExportTasks writes the tasks to w as an indented JSON array, e.g. to back them up or
+// compare them across syncs.
+func ExportTasks(w io.Writer, tasks []WarriorTask) error {
+	out := make([]exportTask, 0, len(tasks))
+	for _, t := range tasks {
+		out = append(out, exportTask{
+			Annotations:  t.Annotations,
+			Assignee:     t.Assignee,
+			Completed:    formatTime(t.Completed),
+			Created:      formatTime(t.Created),
+			CreatedBy:    t.CreatedBy,
+			CustomFields: t.CustomFields,
+			Depends:      t.Depends,
+			Due:          formatTime(t.Due),
+			Followers:    t.Followers,
+			Hearts:       t.Hearts,
+			Modified:     formatTime(t.Modified),
+			Name:         t.Name,
+			Notes:        t.Notes,
+			Parent:       t.Parent,
+			Priority:     t.Priority,
+			Project:      t.Project,
+			Projects:     t.Projects,
+			Recur:        t.Recur,
+			Section:      t.Section,
+			Start:        formatTime(t.Start),
+			Tags:         t.Tags,
+			URL:          t.URL,
+			Xid:          t.Xid,
+			Uuid:         t.Uuid,
+			Deleted:      t.Deleted,
+
+			AssigneeStatus: t.AssigneeStatus,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(out), "ExportTasks")
+}
+
+// ExportTasksCSV writes the tasks to w as CSV, one row per task after a header row.
+// Tags are separated by semicolons, and incomplete tasks have an empty Completed column.
+func ExportTasksCSV(w io.Writer, tasks []WarriorTask) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"Name", "Project", "Section", "Assignee", "Tags", "Created",
+		"Completed", "Xid"}); err != nil {
+		return errors.Wrap(err, "ExportTasksCSV header")
+	}
+	for _, t := range tasks {
+		if err := cw.Write([]string{t.Name, t.Project, t.Section, t.Assignee,
+			strings.Join(t.Tags, ";"), formatTime(t.Created), formatTime(t.Completed),
+			t.Xid}); err != nil {
+			return errors.Wrapf(err, "ExportTasksCSV task: %q", t.Name)
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "ExportTasksCSV")
+}
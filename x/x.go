@@ -3,17 +3,53 @@ package x
 import "time"
 
 type WarriorTask struct {
-	Assignee  string
-	Completed time.Time
-	Created   time.Time
-	Modified  time.Time
-	Name      string
-	Project   string
-	Section   string
-	Tags      []string
-	Xid       string
-	Uuid      string
+	Annotations []string // Read only. Comments from Asana.
+	Assignee    string
+	// AssigneeStatus is where the task is in the assignee's My Tasks in Asana. One of
+	// inbox, today, upcoming or later.
+	AssigneeStatus string
+	Completed      time.Time
+	Created        time.Time
+	CreatedBy      string // Read only. Asana user who created the task.
+	// CustomFields holds the values of Asana custom fields, keyed by Taskwarrior UDA.
+	CustomFields map[string]string
+	Depends      []string // Xids of the tasks this one depends on.
+	Due          time.Time
+	Followers    []string // Users following the task in Asana.
+	Hearts       int      // Read only. Number of hearts, or likes, of the Asana task.
+	Modified     time.Time
+	Name         string
+	Notes        string
+	Parent       string // Xid of the parent task, if this is a subtask. See PendingParent.
+	Priority     string
+	Project      string
+	Recur        string   // Taskwarrior recurrence. Only the current instance is synced.
+	Projects     []string // Other Asana projects the task is in, besides Project.
+	Section      string
+	Start        time.Time // When work on the task starts. Scheduled in Taskwarrior.
+	Tags         []string
+	URL          string // Read only. Permalink to the Asana task.
+	Xid          string // Asana identifier, i.e. gid, of the task.
+	Uuid         string
 
 	// TaskWarrior
 	Deleted bool
 }
+
+// UnmappedPriority is set as the Priority of an Asana task, whose priority can't be
+// mapped to Taskwarrior. Such priorities are left untouched on both sides.
+const UnmappedPriority = "?"
+
+// PendingParent is set as the Parent of a Taskwarrior task, whose parent hasn't been
+// synced to Asana yet. The parent is left untouched in Asana until it is.
+const PendingParent = "?"
+
+// IsAllDay returns whether t represents a date without a time of day. Such dates are
+// kept at local midnight, so they stay on the same calendar day on both sides.
+func IsAllDay(t time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+	h, m, sec := t.Local().Clock()
+	return h == 0 && m == 0 && sec == 0 && t.Nanosecond() == 0
+}